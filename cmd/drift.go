@@ -0,0 +1,66 @@
+package cmd
+
+import (
+	"errors"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/rodcloutier/helm-steer/pkg"
+)
+
+// The exit code of the drift command when a release drifted, the errors
+// exiting with 1
+const driftExitCode = 2
+
+// driftCmd compares a plan to the deployed releases
+var driftCmd = &cobra.Command{
+	Use:   "drift PLAN",
+	Short: "Compare the plan to the deployed releases",
+	Long: `Compare the releases of the plan to the deployed ones without changing
+anything. A release drifts when it is missing, deployed in a namespace of the
+plan without being in the plan, deployed from another chart or version, with
+other values, or when its last operation failed.
+
+The command exits with code 2 when a release drifted and 1 on error, to be run
+by scheduled checks. The report is written in the --output format: text, json
+or junit.`,
+
+	RunE: func(cmd *cobra.Command, args []string) error {
+
+		if len(args) == 0 {
+			return errors.New("Missing required argument plan file")
+		}
+
+		cmd.SilenceUsage = true
+
+		drifted, err := steer.Drift(os.Stdout, args[0], steer.DriftOptions{
+			Namespaces:            driftNamespaces,
+			Output:                driftOutput,
+			Cluster:               cluster,
+			IgnoreClusterIdentity: driftIgnoreClusterIdentity,
+		})
+		if err != nil {
+			return err
+		}
+		if drifted {
+			os.Exit(driftExitCode)
+		}
+		return nil
+	},
+}
+
+// The drift options
+var (
+	driftNamespaces            []string
+	driftOutput                string
+	driftIgnoreClusterIdentity bool
+)
+
+func init() {
+	RootCmd.AddCommand(driftCmd)
+
+	driftCmd.Flags().StringSliceVarP(&driftNamespaces, "namespace", "n", []string{}, "specify the namespace(s) to compare")
+	driftCmd.Flags().StringVarP(&driftOutput, "output", "o", steer.DriftOutputText, "the format of the report (text, json or junit)")
+	driftCmd.Flags().BoolVarP(&driftIgnoreClusterIdentity, "i-know-what-im-doing", "", false, "proceed even if the cluster is not the one expected by the plan")
+}
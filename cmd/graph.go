@@ -0,0 +1,58 @@
+package cmd
+
+import (
+	"errors"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/rodcloutier/helm-steer/pkg"
+)
+
+// graphCmd exports the release dependency graph of a plan
+var graphCmd = &cobra.Command{
+	Use:   "graph PLAN",
+	Short: "Export the release dependency graph of the plan",
+	Long: `Write the release dependency graph of the plan in the --format format: dot,
+mermaid or json. The releases are grouped by namespace and annotated with
+their execution level and the action a run of the plan would perform. The
+edges point from a release to the releases depending on it.
+
+  $ helm steer graph plan.yaml --format dot | dot -Tsvg > plan.svg
+
+With --offline, the cluster is not contacted and the actions are not reported.`,
+
+	RunE: func(cmd *cobra.Command, args []string) error {
+
+		if len(args) == 0 {
+			return errors.New("Missing required argument plan file")
+		}
+
+		cmd.SilenceUsage = true
+
+		return steer.Graph(os.Stdout, args[0], steer.GraphOptions{
+			Namespaces:            graphNamespaces,
+			Format:                graphFormat,
+			Offline:               graphOffline,
+			Cluster:               cluster,
+			IgnoreClusterIdentity: graphIgnoreClusterIdentity,
+		})
+	},
+}
+
+// The graph options
+var (
+	graphNamespaces            []string
+	graphFormat                string
+	graphOffline               bool
+	graphIgnoreClusterIdentity bool
+)
+
+func init() {
+	RootCmd.AddCommand(graphCmd)
+
+	graphCmd.Flags().StringSliceVarP(&graphNamespaces, "namespace", "n", []string{}, "specify the namespace(s) to export")
+	graphCmd.Flags().StringVarP(&graphFormat, "format", "", steer.GraphFormatDot, "the format of the graph (dot, mermaid or json)")
+	graphCmd.Flags().BoolVarP(&graphOffline, "offline", "", false, "do not contact the cluster, the actions are not reported")
+	graphCmd.Flags().BoolVarP(&graphIgnoreClusterIdentity, "i-know-what-im-doing", "", false, "proceed even if the cluster is not the one expected by the plan")
+}
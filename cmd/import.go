@@ -0,0 +1,73 @@
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+
+	"github.com/rodcloutier/helm-steer/pkg/helm"
+	"github.com/rodcloutier/helm-steer/pkg/plan"
+)
+
+// importCmd generates a plan from the releases deployed in the cluster
+var importCmd = &cobra.Command{
+	Use:   "import",
+	Short: "Generate a plan from the currently deployed releases",
+	Long: `Generate a plan deploying the releases of the namespaces as they are
+currently deployed and write it to the standard output. The user supplied
+values of each release are written to --values-dir/namespace/release.yaml.
+
+Tiller does not record the repository of the charts, they are assumed to come
+from the --repo repository.
+
+  $ helm steer import --namespace a,b > plan.yaml`,
+
+	RunE: func(cmd *cobra.Command, args []string) error {
+
+		cmd.SilenceUsage = true
+
+		helm.Configure(cluster)
+		releases, err := helm.List()
+		if err != nil {
+			return err
+		}
+
+		imported := plan.Import(releases, importNamespaces, importRepo, importValuesDir)
+
+		// The values may hold secrets, only the user can read them
+		for _, v := range imported.Values {
+			if err := os.MkdirAll(filepath.Dir(v.Path), 0700); err != nil {
+				return err
+			}
+			if err := ioutil.WriteFile(v.Path, v.Content, 0600); err != nil {
+				return err
+			}
+			fmt.Fprintf(os.Stderr, "Wrote %s\n", v.Path)
+		}
+
+		out, err := imported.YAML()
+		if err != nil {
+			return err
+		}
+		_, err = os.Stdout.Write(out)
+		return err
+	},
+}
+
+// The import options
+var (
+	importNamespaces []string
+	importRepo       string
+	importValuesDir  string
+)
+
+func init() {
+	RootCmd.AddCommand(importCmd)
+
+	importCmd.Flags().StringSliceVarP(&importNamespaces, "namespace", "n", []string{}, "specify the namespace(s) to import")
+	importCmd.Flags().StringVar(&importRepo, "repo", "stable", "the repository of the imported charts")
+	importCmd.Flags().StringVar(&importValuesDir, "values-dir", "values", "the directory the values of the releases are written to")
+}
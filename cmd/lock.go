@@ -0,0 +1,53 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/rodcloutier/helm-steer/pkg/plan"
+)
+
+// lockCmd pins the chart versions of a plan in its lock file
+var lockCmd = &cobra.Command{
+	Use:   "lock PLAN",
+	Short: "Resolve the plan chart versions into a lock file",
+	Long: `Resolve the chart of every release of the plan into an exact version and
+digest from the repository indexes and write them to the plan lock file
+(plan.yaml is locked by plan.lock). Subsequent runs deploy the locked versions
+and refuse to proceed if the plan changed since it was locked.`,
+
+	RunE: func(cmd *cobra.Command, args []string) error {
+
+		if len(args) == 0 {
+			return errors.New("Missing required argument plan file")
+		}
+
+		cmd.SilenceUsage = true
+
+		pl, err := plan.Load(args[0])
+		if err != nil {
+			return err
+		}
+
+		lf, err := pl.Lock()
+		if err != nil {
+			return err
+		}
+
+		path := plan.LockPath(args[0])
+		for _, r := range lf.Releases {
+			fmt.Fprintf(debugWriter, "Locked %s.%s to %s-%s\n", r.Namespace, r.Name, r.Chart, r.Version)
+		}
+		if err := lf.Save(path); err != nil {
+			return err
+		}
+		fmt.Printf("Wrote %s\n", path)
+		return nil
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(lockCmd)
+}
@@ -19,6 +19,8 @@ import (
 	"io"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"time"
 
 	homedir "github.com/mitchellh/go-homedir"
 	"github.com/spf13/cobra"
@@ -26,6 +28,9 @@ import (
 
 	"github.com/rodcloutier/helm-steer/pkg"
 	"github.com/rodcloutier/helm-steer/pkg/format"
+	"github.com/rodcloutier/helm-steer/pkg/helm"
+	"github.com/rodcloutier/helm-steer/pkg/lock"
+	"github.com/rodcloutier/helm-steer/pkg/plan"
 )
 
 var (
@@ -35,6 +40,25 @@ var (
 	namespaces []string
 	// Do not perform the actual options
 	dryRun bool
+	// Upgrade to the latest version satisfying the version ranges
+	latest bool
+	// Allow upgrading a release to a different chart
+	allowChartChange bool
+	// Take over the releases owned by another plan
+	adopt bool
+	// Selects the releases to process
+	selector plan.Selector
+	// The atomic unit of operations
+	transactionScope string
+	// The executor performing the release operations
+	executorName string
+	// The cluster and Tiller connection settings
+	cluster helm.Settings
+	// The TLS flags, overriding the plan cluster ones when specified
+	tls       bool
+	tlsVerify bool
+	// Proceed even if the cluster is not the one expected by the plan
+	ignoreClusterIdentity bool
 	// The debug flag
 	debug bool
 	// The verbose flag
@@ -45,6 +69,14 @@ var (
 	outputWriter io.Writer = ioutil.Discard
 	// The version flag to output the version
 	version bool
+	// The backend used to lock the namespaces
+	lockBackend string
+	// The namespace holding the ConfigMap locks
+	lockNamespace string
+	// The time to wait for a lock held by another run
+	lockTimeout time.Duration
+	// The age after which a lock is considered stale
+	lockStaleAfter time.Duration
 )
 
 // RootCmd represents the base command when called without any subcommands
@@ -52,6 +84,25 @@ var RootCmd = &cobra.Command{
 	Use:   "helm steer [PLAN]",
 	Short: "Install multiple charts according to a plan",
 	Long:  ``,
+	// The plan is an argument, not a sub command
+	Args: cobra.ArbitraryArgs,
+
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		if debug {
+			debugWriter = format.ColorizeWriter(cmd.OutOrStderr(), format.Cyan)
+		}
+		if verbose {
+			outputWriter = cmd.OutOrStderr()
+		}
+		if cmd.Flags().Changed("tls") {
+			cluster.TLS = &tls
+		}
+		if cmd.Flags().Changed("tls-verify") {
+			cluster.TLSVerify = &tlsVerify
+		}
+		// The flags intercepted by helm are exported to the plugin instead
+		cluster = helm.EnvSettings().Merge(cluster)
+	},
 
 	RunE: func(cmd *cobra.Command, args []string) error {
 
@@ -69,18 +120,30 @@ var RootCmd = &cobra.Command{
 			fmt.Println("warning: Specifiying multiple plans is not currently supported. Only the first one will be processed")
 		}
 
-		if debug {
-			debugWriter = format.ColorizeWriter(cmd.OutOrStderr(), format.Cyan)
-		}
-		if verbose {
-			outputWriter = cmd.OutOrStderr()
+		backend, err := newLockBackend()
+		if err != nil {
+			return err
 		}
 
 		cmd.SilenceUsage = true
 
 		// TODO move the command execution in a function here to use a closure on the
 		// writers?
-		return steer.Steer(outputWriter, debugWriter, args[0], namespaces, dryRun)
+		return steer.Steer(outputWriter, debugWriter, args[0], steer.Options{
+			Namespaces:            namespaces,
+			DryRun:                dryRun,
+			Latest:                latest,
+			AllowChartChange:      allowChartChange,
+			Adopt:                 adopt,
+			Selector:              selector,
+			TransactionScope:      transactionScope,
+			Executor:              executorName,
+			Cluster:               cluster,
+			IgnoreClusterIdentity: ignoreClusterIdentity,
+			LockBackend:           backend,
+			LockTimeout:           lockTimeout,
+			LockStaleAfter:        lockStaleAfter,
+		})
 	},
 }
 
@@ -98,8 +161,48 @@ func init() {
 	RootCmd.PersistentFlags().BoolVarP(&debug, "debug", "d", false, "Print the executed commands to stderr")
 	RootCmd.PersistentFlags().BoolVarP(&verbose, "verbose", "v", false, "Print the executed commands output to stderr")
 	RootCmd.Flags().BoolVarP(&dryRun, "dry-run", "", false, "only print the operations but does not perform them")
+	RootCmd.Flags().BoolVarP(&latest, "latest", "", false, "upgrade to the latest version satisfying the version ranges even if the deployed version satisfies them")
+	RootCmd.Flags().BoolVarP(&allowChartChange, "allow-chart-change", "", false, "allow upgrading a release to a chart different from the deployed one")
+	RootCmd.Flags().StringVarP(&transactionScope, "transaction-scope", "", steer.TransactionScopePlan, "the operations undone on failure: all the plan ones (plan) or the ones of the failed namespace (namespace)")
+	RootCmd.Flags().StringVarP(&executorName, "executor", "", plan.ExecutorCLI, "perform the release operations with the helm binary (cli) or the Helm client (library)")
+	RootCmd.Flags().BoolVarP(&adopt, "adopt", "", false, "take over the releases owned by another plan or without recorded owner")
 	RootCmd.Flags().StringSliceVarP(&namespaces, "namespace", "n", []string{}, "specify the namespace(s) to target")
+	RootCmd.Flags().StringSliceVarP(&selector.Releases, "release", "r", []string{}, "specify the release(s) to target")
+	RootCmd.Flags().StringSliceVarP(&selector.Labels, "selector", "l", []string{}, "target the releases with matching labels (key=value or key!=value), among the --release ones if specified")
+	RootCmd.Flags().StringSliceVarP(&selector.Exclude, "exclude", "", []string{}, "specify the release(s) to leave untouched")
+	RootCmd.Flags().BoolVarP(&selector.WithDependencies, "with-dependencies", "", false, "also target the releases on which the targeted releases depend")
+	RootCmd.Flags().BoolVarP(&selector.WithDependents, "with-dependents", "", false, "also target the releases depending on the targeted releases")
 	RootCmd.Flags().BoolVarP(&version, "version", "", false, "show the version and exits")
+	RootCmd.PersistentFlags().StringVarP(&cluster.KubeContext, "kube-context", "", "", "the kube context to use, overriding the plan cluster one")
+	RootCmd.PersistentFlags().StringVarP(&cluster.TillerNamespace, "tiller-namespace", "", "", "the namespace of Tiller, overriding the plan cluster one")
+	RootCmd.PersistentFlags().StringVarP(&cluster.TillerHost, "tiller-host", "", "", "the address of Tiller, overriding the plan cluster one")
+	RootCmd.PersistentFlags().BoolVarP(&tls, "tls", "", false, "connect to Tiller using TLS, --tls=false disables the plan one")
+	RootCmd.PersistentFlags().BoolVarP(&tlsVerify, "tls-verify", "", false, "connect to Tiller using TLS and verify its certificate, --tls-verify=false disables the plan one")
+	RootCmd.PersistentFlags().StringVarP(&cluster.TLSCaCert, "tls-ca-cert", "", "", "the path to the TLS CA certificate file")
+	RootCmd.PersistentFlags().StringVarP(&cluster.TLSCert, "tls-cert", "", "", "the path to the TLS certificate file")
+	RootCmd.PersistentFlags().StringVarP(&cluster.TLSKey, "tls-key", "", "", "the path to the TLS key file")
+	RootCmd.Flags().BoolVarP(&ignoreClusterIdentity, "i-know-what-im-doing", "", false, "proceed even if the cluster is not the one expected by the plan")
+	RootCmd.PersistentFlags().StringVarP(&lockBackend, "lock", "", "file", "the backend used to lock the namespaces (file, configmap or none)")
+	RootCmd.PersistentFlags().StringVarP(&lockNamespace, "lock-namespace", "", "kube-system", "the namespace holding the configmap locks")
+	RootCmd.Flags().DurationVarP(&lockTimeout, "lock-timeout", "", 5*time.Minute, "the time to wait for namespaces locked by another run")
+	RootCmd.Flags().DurationVarP(&lockStaleAfter, "lock-stale-after", "", time.Hour, "the time without renewal after which a lock is considered stale and broken (0 to disable)")
+}
+
+// newLockBackend returns the lock backend selected by the lock flag
+func newLockBackend() (lock.Backend, error) {
+	switch lockBackend {
+	case "none":
+		return nil, nil
+	case "file":
+		dir := filepath.Join(os.TempDir(), "helm-steer-locks")
+		if home := os.Getenv("HELM_HOME"); home != "" {
+			dir = filepath.Join(home, "steer", "locks")
+		}
+		return lock.NewFileBackend(dir), nil
+	case "configmap":
+		return lock.NewConfigMapBackend(lockNamespace), nil
+	}
+	return nil, fmt.Errorf("Unknown lock backend `%s`", lockBackend)
 }
 
 // initConfig reads in config file and ENV variables if set.
@@ -0,0 +1,51 @@
+package cmd
+
+import (
+	"errors"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/rodcloutier/helm-steer/pkg"
+)
+
+// statusCmd summarises the releases of a plan
+var statusCmd = &cobra.Command{
+	Use:   "status PLAN",
+	Short: "Summarise the releases of the plan",
+	Long: `List every release of the plan with its desired chart and version, its
+deployed chart, version, revision, status and last deployed time, and whether a
+run of the plan would install, upgrade or leave it unchanged. Nothing is
+changed.`,
+
+	RunE: func(cmd *cobra.Command, args []string) error {
+
+		if len(args) == 0 {
+			return errors.New("Missing required argument plan file")
+		}
+
+		cmd.SilenceUsage = true
+
+		return steer.Status(os.Stdout, args[0], steer.StatusOptions{
+			Namespaces:            statusNamespaces,
+			Latest:                statusLatest,
+			Cluster:               cluster,
+			IgnoreClusterIdentity: statusIgnoreClusterIdentity,
+		})
+	},
+}
+
+// The status options
+var (
+	statusNamespaces            []string
+	statusLatest                bool
+	statusIgnoreClusterIdentity bool
+)
+
+func init() {
+	RootCmd.AddCommand(statusCmd)
+
+	statusCmd.Flags().StringSliceVarP(&statusNamespaces, "namespace", "n", []string{}, "specify the namespace(s) to summarise")
+	statusCmd.Flags().BoolVarP(&statusLatest, "latest", "", false, "report the releases whose version range allows a newer version as upgraded")
+	statusCmd.Flags().BoolVarP(&statusIgnoreClusterIdentity, "i-know-what-im-doing", "", false, "proceed even if the cluster is not the one expected by the plan")
+}
@@ -0,0 +1,66 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/rodcloutier/helm-steer/pkg/helm"
+	"github.com/rodcloutier/helm-steer/pkg/plan"
+)
+
+// unlockCmd releases the namespaces locks left by an interrupted run
+var unlockCmd = &cobra.Command{
+	Use:   "unlock [PLAN]",
+	Short: "Release the namespaces locks held by another run",
+	Long: `Release the locks of the namespaces of the plan, or of the namespaces
+specified with --namespace. Only use it when the run holding the locks was
+interrupted.`,
+
+	RunE: func(cmd *cobra.Command, args []string) error {
+
+		names := unlockNamespaces
+		settings := cluster
+		if len(args) != 0 {
+			pl, err := plan.Load(args[0])
+			if err != nil {
+				return err
+			}
+			names = pl.NamespaceNames(unlockNamespaces)
+			settings = pl.Cluster.Merge(cluster)
+		}
+		helm.Configure(settings)
+		if len(names) == 0 {
+			return errors.New("Missing required argument plan file or namespace")
+		}
+
+		backend, err := newLockBackend()
+		if err != nil {
+			return err
+		}
+		if backend == nil {
+			return errors.New("Locking is disabled, nothing to unlock")
+		}
+
+		cmd.SilenceUsage = true
+
+		for _, ns := range names {
+			fmt.Fprintf(debugWriter, "Unlocking namespace %s ...\n", ns)
+			if err := backend.Unlock(ns, nil); err != nil {
+				return err
+			}
+			fmt.Printf("Unlocked namespace %s\n", ns)
+		}
+		return nil
+	},
+}
+
+// The namespaces to unlock
+var unlockNamespaces []string
+
+func init() {
+	RootCmd.AddCommand(unlockCmd)
+
+	unlockCmd.Flags().StringSliceVarP(&unlockNamespaces, "namespace", "n", []string{}, "specify the namespace(s) to unlock")
+}
@@ -0,0 +1,152 @@
+package steer
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/rodcloutier/helm-steer/pkg/helm"
+	"github.com/rodcloutier/helm-steer/pkg/plan"
+)
+
+// The formats of the drift report
+const (
+	DriftOutputText  = "text"
+	DriftOutputJSON  = "json"
+	DriftOutputJUnit = "junit"
+)
+
+// DriftOptions are the options of a drift detection
+type DriftOptions struct {
+	// The namespaces compared (empty is all namespaces)
+	Namespaces []string
+	// The format of the report, text, json or junit
+	Output string
+	// The cluster settings, overriding the ones of the plan
+	Cluster helm.Settings
+	// Proceed even if the cluster is not the one expected by the plan
+	IgnoreClusterIdentity bool
+}
+
+// Drift compares the plan to the deployed releases without changing anything
+// and writes the report to w. It reports whether a release drifted.
+func Drift(w io.Writer, planPath string, opts DriftOptions) (bool, error) {
+
+	writeReport, ok := driftWriters[opts.Output]
+	if !ok {
+		return false, fmt.Errorf("Unknown drift output `%s`", opts.Output)
+	}
+
+	// The report is written to w, the warnings must not mix with it
+	pl, err := loadPlan(planPath, opts.Cluster, opts.IgnoreClusterIdentity, os.Stderr, ioutil.Discard)
+	if err != nil {
+		return false, err
+	}
+
+	deployed, err := helm.List()
+	if err != nil {
+		return false, fmt.Errorf("Failed to fetch helm list: %s", err)
+	}
+
+	report, err := pl.Drift(opts.Namespaces, deployed)
+	if err != nil {
+		return false, err
+	}
+
+	if err := writeReport(w, pl.ID(), report); err != nil {
+		return false, err
+	}
+	return report.Drifted(), nil
+}
+
+var driftWriters = map[string]func(io.Writer, string, plan.DriftReport) error{
+	DriftOutputText:  writeDriftText,
+	DriftOutputJSON:  writeDriftJSON,
+	DriftOutputJUnit: writeDriftJUnit,
+}
+
+func writeDriftText(w io.Writer, name string, report plan.DriftReport) error {
+	drifted := report.DriftedReleases()
+	if len(drifted) == 0 {
+		_, err := fmt.Fprintf(w, "No drift, %d releases match plan %s\n", len(report.Releases), name)
+		return err
+	}
+	fmt.Fprintf(w, "Drift detected in %d of %d releases of plan %s\n", len(drifted), len(report.Releases), name)
+	for _, rd := range drifted {
+		for _, d := range rd.Drifts {
+			if _, err := fmt.Fprintf(w, "  %s.%s: %s\n", rd.Namespace, rd.Release, d); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func writeDriftJSON(w io.Writer, name string, report plan.DriftReport) error {
+	out, err := json.MarshalIndent(struct {
+		Plan     string              `json:"plan"`
+		Drifted  bool                `json:"drifted"`
+		Releases []plan.ReleaseDrift `json:"releases"`
+	}{name, report.Drifted(), report.Releases}, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s\n", out)
+	return err
+}
+
+// The JUnit XML report, one test case per release
+type junitFailure struct {
+	Message  string `xml:"message,attr"`
+	Type     string `xml:"type,attr"`
+	Contents string `xml:",chardata"`
+}
+
+type junitTestCase struct {
+	ClassName string        `xml:"classname,attr"`
+	Name      string        `xml:"name,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+}
+
+type junitTestSuite struct {
+	XMLName   xml.Name        `xml:"testsuite"`
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	TestCases []junitTestCase `xml:"testcase"`
+}
+
+func writeDriftJUnit(w io.Writer, name string, report plan.DriftReport) error {
+	suite := junitTestSuite{
+		Name:  "helm-steer drift " + name,
+		Tests: len(report.Releases),
+	}
+	for _, rd := range report.Releases {
+		tc := junitTestCase{ClassName: rd.Namespace, Name: rd.Release}
+		if len(rd.Drifts) != 0 {
+			kinds, lines := []string{}, []string{}
+			for _, d := range rd.Drifts {
+				kinds = append(kinds, d.Kind)
+				lines = append(lines, d.String())
+			}
+			tc.Failure = &junitFailure{
+				Message:  "Drift: " + strings.Join(kinds, ", "),
+				Type:     "drift",
+				Contents: strings.Join(lines, "\n"),
+			}
+			suite.Failures++
+		}
+		suite.TestCases = append(suite.TestCases, tc)
+	}
+
+	out, err := xml.MarshalIndent(suite, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s%s\n", xml.Header, out)
+	return err
+}
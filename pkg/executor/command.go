@@ -1,20 +1,53 @@
 package executor
 
 import (
+	"bytes"
 	"fmt"
 	"io"
+	"os"
 	"os/exec"
 	"strings"
+	"sync"
+	"syscall"
+	"time"
 )
 
+// Result is the outcome of a command
+type Result struct {
+	// The exit code of an executable, 0 on success
+	ExitCode int
+	Stdout   []byte
+	Stderr   []byte
+	Duration time.Duration
+	// The revision and status of the release after a helm operation, when
+	// known
+	Revision int32
+	Status   string
+}
+
 type Command interface {
 	String() string
-	Run(w io.Writer) error
+	Run(w io.Writer) (Result, error)
+}
+
+// ExitError is the failure of an executable command
+type ExitError struct {
+	Command string
+	Result
+}
+
+func (e ExitError) Error() string {
+	msg := fmt.Sprintf("`%s` exited with code %d", e.Command, e.ExitCode)
+	if stderr := strings.TrimSpace(string(e.Stderr)); stderr != "" {
+		msg += ": " + stderr
+	}
+	return msg
 }
 
 type executableCommand struct {
 	entrypoint string
 	args       []string
+	env        []string
 }
 
 func NewExecutableCommand(e string, args []string) Command {
@@ -24,20 +57,60 @@ func NewExecutableCommand(e string, args []string) Command {
 	}
 }
 
+// NewExecutableCommandWithEnv returns a command executed with the
+// specified environment variables (key=value) added to the current ones
+func NewExecutableCommandWithEnv(e string, args []string, env []string) Command {
+	return &executableCommand{
+		entrypoint: e,
+		args:       args,
+		env:        env,
+	}
+}
+
 func (c executableCommand) String() string {
 	items := []string{c.entrypoint}
 	items = append(items, c.args...)
 	return fmt.Sprintf(strings.Join(items, " "))
 }
 
-func (c executableCommand) Run(w io.Writer) error {
+// Run executes the command, its output being written to w as well as
+// captured in the result
+func (c executableCommand) Run(w io.Writer) (Result, error) {
+	var stdout, stderr bytes.Buffer
 	cmd := exec.Command(c.entrypoint, c.args...)
-	cmd.Stdout = w
-	cmd.Stderr = w
-	err := cmd.Start()
-	if err != nil {
-		return err
+	if len(c.env) != 0 {
+		cmd.Env = append(os.Environ(), c.env...)
+	}
+	// exec copies stdout and stderr concurrently, the writes to w must be
+	// serialized
+	out := &lockedWriter{w: w}
+	cmd.Stdout = io.MultiWriter(out, &stdout)
+	cmd.Stderr = io.MultiWriter(out, &stderr)
+
+	start := time.Now()
+	err := cmd.Run()
+	result := Result{
+		Stdout:   stdout.Bytes(),
+		Stderr:   stderr.Bytes(),
+		Duration: time.Since(start),
 	}
-	err = cmd.Wait()
-	return err
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok {
+			result.ExitCode = status.ExitStatus()
+		}
+		return result, ExitError{Command: c.String(), Result: result}
+	}
+	return result, err
+}
+
+// lockedWriter serializes the writes to the underlying writer
+type lockedWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (l *lockedWriter) Write(b []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.w.Write(b)
 }
@@ -1,7 +1,9 @@
 package executor
 
 import (
+	"bytes"
 	"fmt"
+	"strings"
 	"testing"
 )
 
@@ -20,3 +22,27 @@ func TestStringOutput(t *testing.T) {
 		t.Errorf("expected `%s`, got `%s`", expected, result)
 	}
 }
+
+func TestRunResult(t *testing.T) {
+
+	// --- conditions----------------------------------------------------------
+	cmd := NewExecutableCommand("sh", []string{"-c", "echo out; echo err >&2; exit 3"})
+
+	// --- call ---------------------------------------------------------------
+	var output bytes.Buffer
+	result, err := cmd.Run(&output)
+
+	// --- test ---------------------------------------------------------------
+	if result.ExitCode != 3 {
+		t.Errorf("expected exit code 3, got %d", result.ExitCode)
+	}
+	if string(result.Stdout) != "out\n" || string(result.Stderr) != "err\n" {
+		t.Errorf("unexpected stdout `%s` or stderr `%s`", result.Stdout, result.Stderr)
+	}
+	if !strings.Contains(output.String(), "out") {
+		t.Errorf("expected the output to be written, got `%s`", output.String())
+	}
+	if err == nil || !strings.HasSuffix(err.Error(), "exited with code 3: err") {
+		t.Errorf("expected the error to hold the stderr, got `%v`", err)
+	}
+}
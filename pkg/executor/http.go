@@ -0,0 +1,48 @@
+package executor
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+type httpCommand struct {
+	url     string
+	status  int
+	timeout time.Duration
+}
+
+// NewHTTPCommand returns a command that performs a GET on the specified url
+// and fails if the response status does not match the expected one.
+func NewHTTPCommand(url string, status int, timeout time.Duration) Command {
+	if status == 0 {
+		status = http.StatusOK
+	}
+	return &httpCommand{
+		url:     url,
+		status:  status,
+		timeout: timeout,
+	}
+}
+
+func (c httpCommand) String() string {
+	return fmt.Sprintf("GET %s (expecting %d)", c.url, c.status)
+}
+
+func (c httpCommand) Run(w io.Writer) (Result, error) {
+	client := &http.Client{Timeout: c.timeout}
+	start := time.Now()
+	resp, err := client.Get(c.url)
+	result := Result{Duration: time.Since(start)}
+	if err != nil {
+		return result, err
+	}
+	defer resp.Body.Close()
+
+	fmt.Fprintf(w, "GET %s: %s\n", c.url, resp.Status)
+	if resp.StatusCode != c.status {
+		return result, fmt.Errorf("GET %s returned %d, expected %d", c.url, resp.StatusCode, c.status)
+	}
+	return result, nil
+}
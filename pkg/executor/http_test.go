@@ -0,0 +1,31 @@
+package executor
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHTTPCommandStatus(t *testing.T) {
+
+	// --- conditions----------------------------------------------------------
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/healthz" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	healthy := NewHTTPCommand(server.URL+"/healthz", 0, time.Second)
+	unhealthy := NewHTTPCommand(server.URL+"/other", http.StatusOK, time.Second)
+
+	// --- call / test --------------------------------------------------------
+	if _, err := healthy.Run(ioutil.Discard); err != nil {
+		t.Errorf("expected check to succeed, got `%s`", err)
+	}
+	if _, err := unhealthy.Run(ioutil.Discard); err == nil {
+		t.Errorf("expected check to fail on status 503")
+	}
+}
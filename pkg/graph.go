@@ -0,0 +1,171 @@
+package steer
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/rodcloutier/helm-steer/pkg/helm"
+	"github.com/rodcloutier/helm-steer/pkg/plan"
+)
+
+// The formats of the dependency graph
+const (
+	GraphFormatDot     = "dot"
+	GraphFormatMermaid = "mermaid"
+	GraphFormatJSON    = "json"
+)
+
+// GraphOptions are the options of a dependency graph export
+type GraphOptions struct {
+	// The namespaces exported (empty is all namespaces)
+	Namespaces []string
+	// The format of the graph, dot, mermaid or json
+	Format string
+	// Do not connect to the cluster, the actions are not reported
+	Offline bool
+	// The cluster settings, overriding the ones of the plan
+	Cluster helm.Settings
+	// Proceed even if the cluster is not the one expected by the plan
+	IgnoreClusterIdentity bool
+}
+
+// Graph writes to w the release dependency graph of the plan, annotated
+// with the namespaces, the execution levels and the actions a run would
+// perform
+func Graph(w io.Writer, planPath string, opts GraphOptions) error {
+
+	writeGraph, ok := graphWriters[opts.Format]
+	if !ok {
+		return fmt.Errorf("Unknown graph format `%s`", opts.Format)
+	}
+
+	var pl *plan.Plan
+	var statuses []plan.ReleaseStatus
+	var err error
+	if opts.Offline {
+		pl, err = plan.Load(planPath)
+	} else {
+		pl, statuses, err = releaseStatuses(planPath, opts)
+	}
+	if err != nil {
+		return err
+	}
+
+	g, err := pl.Graph(opts.Namespaces, statuses)
+	if err != nil {
+		return err
+	}
+	return writeGraph(w, pl.ID(), g)
+}
+
+// releaseStatuses loads the plan and returns the status of its releases
+// deployed in the cluster
+func releaseStatuses(planPath string, opts GraphOptions) (*plan.Plan, []plan.ReleaseStatus, error) {
+
+	// The graph is written to w, the warnings must not mix with it
+	pl, err := loadPlan(planPath, opts.Cluster, opts.IgnoreClusterIdentity, os.Stderr, ioutil.Discard)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	deployed, err := helm.List()
+	if err != nil {
+		return nil, nil, fmt.Errorf("Failed to fetch helm list: %s", err)
+	}
+	statuses, err := pl.Status(opts.Namespaces, deployed, false)
+	return pl, statuses, err
+}
+
+var graphWriters = map[string]func(io.Writer, string, plan.Graph) error{
+	GraphFormatDot:     writeGraphDot,
+	GraphFormatMermaid: writeGraphMermaid,
+	GraphFormatJSON:    writeGraphJSON,
+}
+
+// graphLabel returns the label of a release: its name, action and level
+func graphLabel(r plan.GraphRelease, separator string) string {
+	details := fmt.Sprintf("level %d", r.Level)
+	if r.Action != "" {
+		details = r.Action + ", " + details
+	}
+	return r.Name + separator + details
+}
+
+// graphNamespaces returns the namespaces of the releases in order of first
+// appearance, and their releases
+func graphNamespaces(g plan.Graph) ([]string, map[string][]plan.GraphRelease) {
+	names := []string{}
+	releases := map[string][]plan.GraphRelease{}
+	for _, r := range g.Releases {
+		if _, ok := releases[r.Namespace]; !ok {
+			names = append(names, r.Namespace)
+		}
+		releases[r.Namespace] = append(releases[r.Namespace], r)
+	}
+	return names, releases
+}
+
+func writeGraphDot(w io.Writer, name string, g plan.Graph) error {
+	lines := []string{fmt.Sprintf("digraph %q {", name), "  rankdir=LR;"}
+	names, releases := graphNamespaces(g)
+	for i, ns := range names {
+		lines = append(lines,
+			fmt.Sprintf("  subgraph cluster_%d {", i),
+			fmt.Sprintf("    label=%q;", ns))
+		for _, r := range releases[ns] {
+			lines = append(lines, fmt.Sprintf("    %q [label=%q];", r.Name, graphLabel(r, "\n")))
+		}
+		lines = append(lines, "  }")
+	}
+	for _, e := range g.Edges {
+		lines = append(lines, fmt.Sprintf("  %q -> %q [label=%q];", e.From, e.To, e.Condition))
+	}
+	lines = append(lines, "}")
+	_, err := fmt.Fprintln(w, strings.Join(lines, "\n"))
+	return err
+}
+
+func writeGraphMermaid(w io.Writer, name string, g plan.Graph) error {
+	// The release names are not always valid mermaid ids
+	ids := map[string]string{}
+	for i, r := range g.Releases {
+		ids[r.Name] = fmt.Sprintf("r%d", i)
+	}
+	id := func(release string) string {
+		if id, ok := ids[release]; ok {
+			return id
+		}
+		return release
+	}
+
+	lines := []string{"graph LR"}
+	names, releases := graphNamespaces(g)
+	for i, ns := range names {
+		lines = append(lines, fmt.Sprintf("  subgraph ns%d [%s]", i, ns))
+		for _, r := range releases[ns] {
+			lines = append(lines, fmt.Sprintf("    %s[\"%s\"]", id(r.Name), graphLabel(r, "<br/>")))
+		}
+		lines = append(lines, "  end")
+	}
+	for _, e := range g.Edges {
+		lines = append(lines, fmt.Sprintf("  %s -->|%s| %s", id(e.From), e.Condition, id(e.To)))
+	}
+	_, err := fmt.Fprintln(w, strings.Join(lines, "\n"))
+	return err
+}
+
+func writeGraphJSON(w io.Writer, name string, g plan.Graph) error {
+	out, err := json.MarshalIndent(struct {
+		Plan string `json:"plan"`
+		plan.Graph
+	}{name, g}, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s\n", out)
+	return err
+}
@@ -0,0 +1,61 @@
+package helm
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"sort"
+
+	"k8s.io/helm/pkg/proto/hapi/chart"
+)
+
+// ChartDigest returns a digest of the chart content: its metadata, values,
+// templates, files and dependencies. A chart loaded from a directory has the
+// same digest as the same chart deployed in a release.
+func ChartDigest(c *chart.Chart) string {
+	h := sha256.New()
+	writeChart(h, c)
+	return fmt.Sprintf("sha256:%x", h.Sum(nil))
+}
+
+func writeChart(h hash.Hash, c *chart.Chart) {
+	if c == nil {
+		return
+	}
+	if c.Metadata != nil {
+		fmt.Fprintf(h, "chart:%s:%s\n", c.Metadata.Name, c.Metadata.Version)
+	}
+	if c.Values != nil {
+		fmt.Fprintf(h, "values:%d\n%s", len(c.Values.Raw), c.Values.Raw)
+	}
+
+	templates := make([]*chart.Template, len(c.Templates))
+	copy(templates, c.Templates)
+	sort.Slice(templates, func(i, j int) bool { return templates[i].Name < templates[j].Name })
+	for _, t := range templates {
+		fmt.Fprintf(h, "template:%s:%d\n", t.Name, len(t.Data))
+		h.Write(t.Data)
+	}
+
+	files := make([]*chart.Any, len(c.Files))
+	copy(files, c.Files)
+	sort.Slice(files, func(i, j int) bool { return files[i].TypeUrl < files[j].TypeUrl })
+	for _, f := range files {
+		fmt.Fprintf(h, "file:%s:%d\n", f.TypeUrl, len(f.Value))
+		h.Write(f.Value)
+	}
+
+	deps := make([]*chart.Chart, len(c.Dependencies))
+	copy(deps, c.Dependencies)
+	sort.Slice(deps, func(i, j int) bool { return chartName(deps[i]) < chartName(deps[j]) })
+	for _, d := range deps {
+		writeChart(h, d)
+	}
+}
+
+func chartName(c *chart.Chart) string {
+	if c.Metadata == nil {
+		return ""
+	}
+	return c.Metadata.Name
+}
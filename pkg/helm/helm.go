@@ -1,7 +1,7 @@
 package helm
 
 import (
-	"os"
+	"sync"
 
 	"github.com/ghodss/yaml"
 	"k8s.io/helm/pkg/helm"
@@ -14,27 +14,65 @@ func init() {
 	dryRun = true
 }
 
-func newClient() helm.Interface {
-	// options := []helm.Option{helm.Host(settings.TillerHost)}
-	options := []helm.Option{helm.Host(os.Getenv("TILLER_HOST"))}
-	// if tlsVerify || tlsEnable {
-	// 	tlsopts := tlsutil.Options{KeyFile: tlsKeyFile, CertFile: tlsCertFile, InsecureSkipVerify: true}
-	// 	if tlsVerify {
-	// 		tlsopts.CaCertFile = tlsCaCertFile
-	// 		tlsopts.InsecureSkipVerify = false
-	// 	}
-	// 	tlscfg, err := tlsutil.ClientConfig(tlsopts)
-	// 	if err != nil {
-	// 		fmt.Fprintln(os.Stderr, err)
-	// 		os.Exit(2)
-	// 	}
-	// 	options = append(options, helm.WithTLS(tlscfg))
-	// }
-	return helm.NewClient(options...)
+// newClient returns a client connected to the Tiller of the settings. The
+// returned function closes the connection.
+func newClient() (helm.Interface, func() error, error) {
+	tlscfg, err := settings.tlsConfig()
+	if err != nil {
+		return nil, nil, err
+	}
+	host, closeFunc, err := tillerHost()
+	if err != nil {
+		return nil, nil, err
+	}
+	options := []helm.Option{helm.Host(host)}
+	if tlscfg != nil {
+		options = append(options, helm.WithTLS(tlscfg))
+	}
+	return helm.NewClient(options...), closeFunc, nil
+}
+
+// The client shared by the release commands, connected on first use
+var (
+	sharedMutex sync.Mutex
+	shared      helm.Interface
+	sharedClose func() error
+)
+
+// sharedClient returns the client shared by the release commands, keeping a
+// single connection to Tiller for the whole run instead of one per operation
+func sharedClient() (helm.Interface, error) {
+	sharedMutex.Lock()
+	defer sharedMutex.Unlock()
+	if shared == nil {
+		client, closeFunc, err := newClient()
+		if err != nil {
+			return nil, err
+		}
+		shared, sharedClose = client, closeFunc
+	}
+	return shared, nil
+}
+
+// Close closes the connection of the client shared by the release commands,
+// if any
+func Close() error {
+	sharedMutex.Lock()
+	defer sharedMutex.Unlock()
+	if shared == nil {
+		return nil
+	}
+	err := sharedClose()
+	shared, sharedClose = nil, nil
+	return err
 }
 
 func List() ([]*release.Release, error) {
-	client := newClient()
+	client, closeFunc, err := newClient()
+	if err != nil {
+		return nil, err
+	}
+	defer closeFunc()
 
 	var codes = []release.Status_Code{
 		release.Status_FAILED,
@@ -0,0 +1,387 @@
+package helm
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ghodss/yaml"
+	"k8s.io/helm/pkg/chartutil"
+	"k8s.io/helm/pkg/downloader"
+	"k8s.io/helm/pkg/getter"
+	"k8s.io/helm/pkg/helm"
+	helm_env "k8s.io/helm/pkg/helm/environment"
+	"k8s.io/helm/pkg/proto/hapi/chart"
+	"k8s.io/helm/pkg/proto/hapi/release"
+	"k8s.io/helm/pkg/strvals"
+
+	"github.com/rodcloutier/helm-steer/pkg/executor"
+)
+
+// ChartOptions locate the chart of a release and its values
+type ChartOptions struct {
+	// A local chart directory or archive, or a repository chart reference
+	Chart   string
+	Version string
+	// Verify the provenance of a repository chart with the keyring
+	Verify  bool
+	Keyring string
+	// The values files and the values set on the command line
+	Values []string
+	Set    []string
+}
+
+// InstallOptions are the options of a release installation
+type InstallOptions struct {
+	ChartOptions
+	Name         string
+	Namespace    string
+	DryRun       bool
+	DisableHooks bool
+	Replace      bool
+	Wait         bool
+	Timeout      int64
+}
+
+// UpgradeOptions are the options of a release upgrade
+type UpgradeOptions struct {
+	ChartOptions
+	Name         string
+	DryRun       bool
+	DisableHooks bool
+	Force        bool
+	Recreate     bool
+	ResetValues  bool
+	ReuseValues  bool
+	Wait         bool
+	Timeout      int64
+}
+
+// RollbackOptions are the options of a release rollback
+type RollbackOptions struct {
+	Name         string
+	Revision     int32
+	DryRun       bool
+	DisableHooks bool
+	Force        bool
+	Recreate     bool
+	Wait         bool
+	Timeout      int64
+}
+
+// DeleteOptions are the options of a release deletion
+type DeleteOptions struct {
+	Name         string
+	DryRun       bool
+	DisableHooks bool
+	Purge        bool
+	Timeout      int64
+}
+
+// ReleaseError is the failure of a release operation reported by Tiller
+type ReleaseError struct {
+	Operation string
+	Release   string
+	Err       error
+}
+
+func (e ReleaseError) Error() string {
+	return fmt.Sprintf("Failed to %s release %s: %s", e.Operation, e.Release, e.Err)
+}
+
+// releaseCommand performs a release operation through the Helm client
+type releaseCommand struct {
+	description string
+	operation   string
+	release     string
+	run         func(client helm.Interface) (*release.Release, error)
+}
+
+func (c releaseCommand) String() string {
+	return c.description
+}
+
+func (c releaseCommand) Run(w io.Writer) (executor.Result, error) {
+	client, err := sharedClient()
+	if err != nil {
+		return executor.Result{}, err
+	}
+
+	start := time.Now()
+	r, err := c.run(client)
+	result := executor.Result{Duration: time.Since(start)}
+	if err != nil {
+		return result, ReleaseError{Operation: c.operation, Release: c.release, Err: err}
+	}
+	if r != nil {
+		result.Revision = r.Version
+		result.Status = r.GetInfo().GetStatus().GetCode().String()
+		fmt.Fprintf(w, "Release %s revision %d %s\n", r.Name, result.Revision, result.Status)
+	}
+	return result, nil
+}
+
+// cliReleaseCommand is a helm command performing an operation on a release
+type cliReleaseCommand struct {
+	executor.Command
+	release string
+}
+
+// ReleaseCommand returns the helm command with the specified arguments
+// performing an operation on the release. Its result holds the revision and
+// the status of the release after the operation.
+func ReleaseCommand(release string, args ...string) executor.Command {
+	return &cliReleaseCommand{
+		Command: Command(args...),
+		release: release,
+	}
+}
+
+func (c cliReleaseCommand) Run(w io.Writer) (executor.Result, error) {
+	result, err := c.Command.Run(w)
+	if err != nil {
+		return result, err
+	}
+	result.Status = parseStatus(result.Stdout)
+	// helm does not print the revision, the release history has it
+	if r, err := lastRevision(c.release); err == nil && r != nil {
+		result.Revision = r.Version
+		result.Status = r.GetInfo().GetStatus().GetCode().String()
+	}
+	return result, nil
+}
+
+// parseStatus returns the release status printed by helm, if any
+func parseStatus(output []byte) string {
+	for _, line := range strings.Split(string(output), "\n") {
+		if strings.HasPrefix(line, "STATUS:") {
+			return strings.TrimSpace(strings.TrimPrefix(line, "STATUS:"))
+		}
+	}
+	return ""
+}
+
+// lastRevision returns the last revision of the release, nil if the release
+// does not exist
+func lastRevision(name string) (*release.Release, error) {
+	client, err := sharedClient()
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := client.ReleaseHistory(name, helm.WithMaxHistory(1))
+	if err != nil {
+		return nil, err
+	}
+	if len(res.Releases) == 0 {
+		return nil, nil
+	}
+	return res.Releases[0], nil
+}
+
+// NewInstallCommand returns the command installing a release with the
+// Helm client
+func NewInstallCommand(opts InstallOptions) executor.Command {
+	return &releaseCommand{
+		description: fmt.Sprintf("install %s from %s in namespace %s", opts.Name, opts.ChartOptions, opts.Namespace),
+		operation:   "install",
+		release:     opts.Name,
+		run: func(client helm.Interface) (*release.Release, error) {
+			c, values, err := opts.ChartOptions.load()
+			if err != nil {
+				return nil, err
+			}
+			res, err := client.InstallReleaseFromChart(c, opts.Namespace,
+				helm.ValueOverrides(values),
+				helm.ReleaseName(opts.Name),
+				helm.InstallDryRun(opts.DryRun),
+				helm.InstallReuseName(opts.Replace),
+				helm.InstallDisableHooks(opts.DisableHooks),
+				helm.InstallTimeout(opts.Timeout),
+				helm.InstallWait(opts.Wait))
+			if err != nil {
+				return nil, err
+			}
+			return res.GetRelease(), nil
+		},
+	}
+}
+
+// NewUpgradeCommand returns the command upgrading a release with the Helm
+// client
+func NewUpgradeCommand(opts UpgradeOptions) executor.Command {
+	return &releaseCommand{
+		description: fmt.Sprintf("upgrade %s to %s", opts.Name, opts.ChartOptions),
+		operation:   "upgrade",
+		release:     opts.Name,
+		run: func(client helm.Interface) (*release.Release, error) {
+			c, values, err := opts.ChartOptions.load()
+			if err != nil {
+				return nil, err
+			}
+			res, err := client.UpdateReleaseFromChart(opts.Name, c,
+				helm.UpdateValueOverrides(values),
+				helm.UpgradeDryRun(opts.DryRun),
+				helm.UpgradeDisableHooks(opts.DisableHooks),
+				helm.UpgradeForce(opts.Force),
+				helm.UpgradeRecreate(opts.Recreate),
+				helm.ResetValues(opts.ResetValues),
+				helm.ReuseValues(opts.ReuseValues),
+				helm.UpgradeTimeout(opts.Timeout),
+				helm.UpgradeWait(opts.Wait))
+			if err != nil {
+				return nil, err
+			}
+			return res.GetRelease(), nil
+		},
+	}
+}
+
+// NewRollbackCommand returns the command rolling back a release with the
+// Helm client
+func NewRollbackCommand(opts RollbackOptions) executor.Command {
+	return &releaseCommand{
+		description: fmt.Sprintf("rollback %s to revision %d", opts.Name, opts.Revision),
+		operation:   "rollback",
+		release:     opts.Name,
+		run: func(client helm.Interface) (*release.Release, error) {
+			res, err := client.RollbackRelease(opts.Name,
+				helm.RollbackVersion(opts.Revision),
+				helm.RollbackDryRun(opts.DryRun),
+				helm.RollbackDisableHooks(opts.DisableHooks),
+				helm.RollbackForce(opts.Force),
+				helm.RollbackRecreate(opts.Recreate),
+				helm.RollbackTimeout(opts.Timeout),
+				helm.RollbackWait(opts.Wait))
+			if err != nil {
+				return nil, err
+			}
+			return res.GetRelease(), nil
+		},
+	}
+}
+
+// NewDeleteCommand returns the command deleting a release with the Helm
+// client
+func NewDeleteCommand(opts DeleteOptions) executor.Command {
+	return &releaseCommand{
+		description: fmt.Sprintf("delete %s", opts.Name),
+		operation:   "delete",
+		release:     opts.Name,
+		run: func(client helm.Interface) (*release.Release, error) {
+			_, err := client.DeleteRelease(opts.Name,
+				helm.DeleteDryRun(opts.DryRun),
+				helm.DeleteDisableHooks(opts.DisableHooks),
+				helm.DeletePurge(opts.Purge),
+				helm.DeleteTimeout(opts.Timeout))
+			return nil, err
+		},
+	}
+}
+
+func (o ChartOptions) String() string {
+	if o.Version == "" {
+		return o.Chart
+	}
+	return fmt.Sprintf("%s-%s", o.Chart, o.Version)
+}
+
+// load returns the chart and the merged values. Repository charts are
+// downloaded from the repositories configured in $HELM_HOME.
+func (o ChartOptions) load() (*chart.Chart, []byte, error) {
+
+	path := o.Chart
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		dir, err := ioutil.TempDir("", "helm-steer-chart")
+		if err != nil {
+			return nil, nil, err
+		}
+		defer os.RemoveAll(dir)
+
+		dl := downloader.ChartDownloader{
+			HelmHome: Home(),
+			Out:      ioutil.Discard,
+			Keyring:  o.Keyring,
+			Getters:  getter.All(helm_env.EnvSettings{Home: Home()}),
+		}
+		if o.Verify {
+			dl.Verify = downloader.VerifyAlways
+		}
+		path, _, err = dl.DownloadTo(o.Chart, o.Version, dir)
+		if err != nil {
+			return nil, nil, fmt.Errorf("Failed to download chart %s: %s", o, err)
+		}
+	}
+
+	c, err := chartutil.Load(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Failed to load chart %s: %s", o, err)
+	}
+	values, err := o.values()
+	if err != nil {
+		return nil, nil, err
+	}
+	return c, values, nil
+}
+
+// values merges the values files in order then applies the values set
+func (o ChartOptions) values() ([]byte, error) {
+	base := map[string]interface{}{}
+	for _, file := range o.Values {
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		current := map[string]interface{}{}
+		if err := yaml.Unmarshal(content, &current); err != nil {
+			return nil, fmt.Errorf("Failed to parse values file %s: %s", file, err)
+		}
+		mergeValues(base, current)
+	}
+	for _, value := range o.Set {
+		if err := strvals.ParseInto(value, base); err != nil {
+			return nil, fmt.Errorf("Failed to parse set value `%s`: %s", value, err)
+		}
+	}
+	return yaml.Marshal(base)
+}
+
+// Values returns the user supplied values of the values files and the set
+// values, merged like helm does
+func Values(files, set []string) (map[string]interface{}, error) {
+	content, err := ChartOptions{Values: files, Set: set}.values()
+	if err != nil {
+		return nil, err
+	}
+	return ParseValues(string(content))
+}
+
+// ParseValues parses the raw values of a release
+func ParseValues(raw string) (map[string]interface{}, error) {
+	values := map[string]interface{}{}
+	if err := yaml.Unmarshal([]byte(raw), &values); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+// mergeValues merges src into dest, the nested maps being merged
+// recursively
+func mergeValues(dest, src map[string]interface{}) {
+	for k, v := range src {
+		nested, ok := v.(map[string]interface{})
+		if !ok {
+			dest[k] = v
+			continue
+		}
+		destNested, ok := dest[k].(map[string]interface{})
+		if !ok {
+			dest[k] = nested
+			continue
+		}
+		mergeValues(destNested, nested)
+	}
+}
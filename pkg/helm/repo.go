@@ -0,0 +1,162 @@
+package helm
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Masterminds/semver"
+	"github.com/ghodss/yaml"
+	homedir "github.com/mitchellh/go-homedir"
+	"k8s.io/helm/pkg/helm/helmpath"
+	"k8s.io/helm/pkg/repo"
+)
+
+// Home returns the helm home directory
+func Home() helmpath.Home {
+	if home := os.Getenv("HELM_HOME"); home != "" {
+		return helmpath.Home(home)
+	}
+	dir, err := homedir.Dir()
+	if err != nil {
+		return helmpath.Home(".helm")
+	}
+	return helmpath.Home(filepath.Join(dir, ".helm"))
+}
+
+// SplitChart splits a repository chart reference (repo/name) into its
+// repository and chart names
+func SplitChart(chart string) (string, string, error) {
+	parts := strings.Split(chart, "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", fmt.Errorf("`%s` is not a repository chart (repo/name)", chart)
+	}
+	return parts[0], parts[1], nil
+}
+
+// ResolveVersion returns the highest version of the repository chart
+// satisfying the constraint, according to the local repository index
+func ResolveVersion(chart, constraint string) (*repo.ChartVersion, error) {
+
+	repoName, name, err := SplitChart(chart)
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := semver.NewConstraint(constraint)
+	if err != nil {
+		return nil, err
+	}
+
+	index, err := repo.LoadIndexFile(Home().CacheIndex(repoName))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to load the index of repository %s: %s", repoName, err)
+	}
+
+	var best *repo.ChartVersion
+	var bestVersion *semver.Version
+	for _, cv := range index.Entries[name] {
+		v, err := semver.NewVersion(cv.Version)
+		if err != nil {
+			continue
+		}
+		if !c.Check(v) {
+			continue
+		}
+		if bestVersion == nil || v.GreaterThan(bestVersion) {
+			best, bestVersion = cv, v
+		}
+	}
+
+	if best == nil {
+		return nil, fmt.Errorf("No version of chart %s satisfies `%s`", chart, constraint)
+	}
+	return best, nil
+}
+
+// Repositories returns the URL of the locally configured repositories by
+// name
+func Repositories() (map[string]string, error) {
+	repositories := map[string]string{}
+	path := Home().RepositoryFile()
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return repositories, nil
+	}
+	f, err := repo.LoadRepositoriesFile(path)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range f.Repositories {
+		repositories[entry.Name] = entry.URL
+	}
+	return repositories, nil
+}
+
+// RepositoryEntry is a repository of the repositories file. The credentials
+// are only used by helm 2.9 or later.
+type RepositoryEntry struct {
+	Name     string `json:"name"`
+	Cache    string `json:"cache"`
+	URL      string `json:"url"`
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+	CertFile string `json:"certFile"`
+	KeyFile  string `json:"keyFile"`
+	CAFile   string `json:"caFile"`
+}
+
+// AddRepository adds the repository to the repositories file, replacing the
+// one of the same name. The file holding credentials, it is replaced by a
+// file readable by the user only.
+func AddRepository(entry RepositoryEntry) error {
+	path := Home().RepositoryFile()
+	file := map[string]interface{}{"apiVersion": "v1"}
+	content, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err == nil {
+		if err := yaml.Unmarshal(content, &file); err != nil {
+			return fmt.Errorf("Failed to read %s: %s", path, err)
+		}
+	}
+
+	entries := []interface{}{}
+	current, _ := file["repositories"].([]interface{})
+	for _, e := range current {
+		if m, ok := e.(map[string]interface{}); ok && m["name"] == entry.Name {
+			continue
+		}
+		entries = append(entries, e)
+	}
+	if entry.Cache == "" {
+		entry.Cache = Home().CacheIndex(entry.Name)
+	}
+	file["repositories"] = append(entries, entry)
+
+	content, err = yaml.Marshal(file)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	// The temporary file is created readable by the user only
+	f, err := ioutil.TempFile(filepath.Dir(path), ".repositories")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(content)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
@@ -0,0 +1,61 @@
+package helm
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestAddRepository(t *testing.T) {
+
+	// --- conditions----------------------------------------------------------
+	dir, err := ioutil.TempDir("", "helm-home")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer os.Setenv("HELM_HOME", os.Getenv("HELM_HOME"))
+	os.Setenv("HELM_HOME", dir)
+
+	if err := os.MkdirAll(Home().Repository(), 0755); err != nil {
+		t.Fatal(err)
+	}
+	content := `apiVersion: v1
+repositories:
+- name: stable
+  url: https://kubernetes-charts.storage.googleapis.com
+- name: private
+  url: https://old.example.com
+`
+	if err := ioutil.WriteFile(Home().RepositoryFile(), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// --- call ---------------------------------------------------------------
+	err = AddRepository(RepositoryEntry{Name: "private", URL: "https://private.example.com", Username: "user", Password: "secret"})
+
+	// --- test ---------------------------------------------------------------
+	if err != nil {
+		t.Fatalf("unexpected error `%s`", err)
+	}
+	fi, err := os.Stat(Home().RepositoryFile())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0600 {
+		t.Errorf("expected the repositories file to be readable by the user only, got %s", fi.Mode())
+	}
+	written, err := ioutil.ReadFile(Home().RepositoryFile())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{"name: stable", "url: https://private.example.com", "password: secret"} {
+		if !strings.Contains(string(written), expected) {
+			t.Errorf("expected `%s` in the repositories file, got\n%s", expected, written)
+		}
+	}
+	if strings.Contains(string(written), "old.example.com") {
+		t.Errorf("expected the previous private repository to be replaced, got\n%s", written)
+	}
+}
@@ -0,0 +1,197 @@
+package helm
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/rodcloutier/helm-steer/pkg/executor"
+	"github.com/rodcloutier/helm-steer/pkg/kube"
+)
+
+const (
+	// The namespace of Tiller when not specified
+	defaultTillerNamespace = "kube-system"
+	// The selector and port of the Tiller pod
+	tillerSelector = "app=helm,name=tiller"
+	tillerPort     = 44134
+)
+
+// Settings are the connection settings to the cluster and to Tiller. They
+// apply to the Helm client and to every helm and kubectl command.
+type Settings struct {
+	// The kube context, the current one when empty
+	KubeContext string `json:"kubeContext"`
+	// The namespace of Tiller, kube-system when empty
+	TillerNamespace string `json:"tillerNamespace"`
+	// The address of Tiller, reached through the kube context when empty
+	TillerHost string `json:"tillerHost"`
+	// Connect to Tiller using TLS, verifying its certificate with TLSVerify.
+	// Nil when not specified, so that an override can disable them.
+	TLS       *bool  `json:"tls"`
+	TLSVerify *bool  `json:"tlsVerify"`
+	TLSCaCert string `json:"tlsCaCert"`
+	TLSCert   string `json:"tlsCert"`
+	TLSKey    string `json:"tlsKey"`
+}
+
+// The settings in use
+var settings Settings
+
+// Configure sets the settings used by the client and the commands, the TLS
+// files defaulting to the HELM_TLS_* environment variables and $HELM_HOME
+func Configure(s Settings) {
+	settings = s.withTLSDefaults()
+	kube.SetContext(s.KubeContext)
+}
+
+// EnvSettings returns the settings exported by helm to its plugins. Helm
+// consumes its --kube-context and --tiller-namespace flags rather than
+// passing them to the plugin, it exports them in $HELM_KUBECONTEXT and
+// $TILLER_NAMESPACE instead. The default Tiller namespace is ignored as helm
+// always exports it.
+func EnvSettings() Settings {
+	s := Settings{KubeContext: os.Getenv("HELM_KUBECONTEXT")}
+	if namespace := os.Getenv("TILLER_NAMESPACE"); namespace != defaultTillerNamespace {
+		s.TillerNamespace = namespace
+	}
+	return s
+}
+
+// Merge returns the settings overridden by the specified fields of o
+func (s Settings) Merge(o Settings) Settings {
+	override := func(v *string, o string) {
+		if o != "" {
+			*v = o
+		}
+	}
+	override(&s.KubeContext, o.KubeContext)
+	override(&s.TillerNamespace, o.TillerNamespace)
+	override(&s.TillerHost, o.TillerHost)
+	override(&s.TLSCaCert, o.TLSCaCert)
+	override(&s.TLSCert, o.TLSCert)
+	override(&s.TLSKey, o.TLSKey)
+	if o.TLS != nil {
+		s.TLS = o.TLS
+	}
+	if o.TLSVerify != nil {
+		s.TLSVerify = o.TLSVerify
+	}
+	return s
+}
+
+// verify reports whether the certificate of Tiller is verified
+func (s Settings) verify() bool {
+	return s.TLSVerify != nil && *s.TLSVerify
+}
+
+// tls reports whether Tiller is reached using TLS, implied by verify
+func (s Settings) tls() bool {
+	return (s.TLS != nil && *s.TLS) || s.verify()
+}
+
+// tunneled reports whether Tiller must be reached through a port forwarded
+// with the kube context rather than the one opened by helm for the plugin
+func (s Settings) tunneled() bool {
+	return s.TillerHost == "" && (s.KubeContext != "" || s.TillerNamespace != "")
+}
+
+// host returns the address of Tiller given to the helm commands, empty when
+// helm must find Tiller itself using the kube context
+func (s Settings) host() string {
+	if s.TillerHost != "" || s.tunneled() {
+		return s.TillerHost
+	}
+	// Reach the Tiller of the tunnel opened by helm for the plugin, the one
+	// the client connects to
+	return os.Getenv("TILLER_HOST")
+}
+
+// flags returns the helm flags applying the settings
+func (s Settings) flags() []string {
+	flags := []string{}
+	if s.KubeContext != "" {
+		flags = append(flags, "--kube-context", s.KubeContext)
+	}
+	if s.TillerNamespace != "" {
+		flags = append(flags, "--tiller-namespace", s.TillerNamespace)
+	}
+	if host := s.host(); host != "" {
+		flags = append(flags, "--host", host)
+	}
+	if s.tls() {
+		flags = append(flags, "--tls")
+	}
+	if s.verify() {
+		flags = append(flags, "--tls-verify")
+	}
+	if s.TLSCaCert != "" {
+		flags = append(flags, "--tls-ca-cert", s.TLSCaCert)
+	}
+	if s.TLSCert != "" {
+		flags = append(flags, "--tls-cert", s.TLSCert)
+	}
+	if s.TLSKey != "" {
+		flags = append(flags, "--tls-key", s.TLSKey)
+	}
+	return flags
+}
+
+// String describes the cluster targeted by the settings
+func (s Settings) String() string {
+	context := s.KubeContext
+	if context == "" {
+		context = "current context"
+	}
+	tiller := s.TillerHost
+	if tiller == "" {
+		namespace := s.TillerNamespace
+		if namespace == "" {
+			namespace = defaultTillerNamespace
+		}
+		tiller = "namespace " + namespace
+	}
+	return fmt.Sprintf("%s (tiller in %s)", context, tiller)
+}
+
+// Command returns the helm command with the specified arguments, talking to
+// the Tiller of the settings
+func Command(args ...string) executor.Command {
+	return executor.NewExecutableCommand("helm", append(args, settings.flags()...))
+}
+
+// ClientVersion returns the version of the helm binary
+func ClientVersion() (string, error) {
+	result, err := executor.NewExecutableCommand("helm", []string{"version", "--client", "--short"}).Run(ioutil.Discard)
+	if err != nil {
+		return "", err
+	}
+	// Client: v2.5.0+g012cb0a
+	version := strings.TrimSpace(string(result.Stdout))
+	version = strings.TrimPrefix(version, "Client: ")
+	return strings.TrimPrefix(version, "v"), nil
+}
+
+// tillerHost returns the address the client connects to, forwarding a port
+// to Tiller with the kube context when required. The returned function
+// stops the forwarding.
+func tillerHost() (string, func() error, error) {
+	if !settings.tunneled() {
+		return settings.host(), func() error { return nil }, nil
+	}
+
+	namespace := settings.TillerNamespace
+	if namespace == "" {
+		namespace = defaultTillerNamespace
+	}
+	out, err := kube.Output("get", "pods", "--namespace", namespace, "--selector", tillerSelector, "--output", "jsonpath={.items[0].metadata.name}")
+	if err != nil {
+		return "", nil, fmt.Errorf("Failed to find Tiller in %s: %s", settings, err)
+	}
+	pod := strings.TrimSpace(string(out))
+	if pod == "" {
+		return "", nil, fmt.Errorf("Tiller not found in %s", settings)
+	}
+	return kube.PortForward(namespace, pod, tillerPort)
+}
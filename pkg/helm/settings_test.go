@@ -0,0 +1,103 @@
+package helm
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestSettingsFlags(t *testing.T) {
+
+	// --- conditions----------------------------------------------------------
+	enabled, disabled := true, false
+	planSettings := Settings{KubeContext: "staging", TillerNamespace: "tiller", TLS: &enabled, TLSVerify: &enabled}
+	cliSettings := Settings{KubeContext: "production", TLSVerify: &disabled, TLSCert: "cert.pem"}
+
+	// --- call ---------------------------------------------------------------
+	s := planSettings.Merge(cliSettings)
+
+	// --- test ---------------------------------------------------------------
+	expected := []string{
+		"--kube-context", "production",
+		"--tiller-namespace", "tiller",
+		"--tls",
+		"--tls-cert", "cert.pem",
+	}
+	if flags := s.flags(); !reflect.DeepEqual(flags, expected) {
+		t.Errorf("expected flags %v, got %v", expected, flags)
+	}
+	if !s.tunneled() {
+		t.Errorf("expected Tiller to be reached through the kube context")
+	}
+
+	// The plan TLS can be disabled by the command flags
+	s = planSettings.Merge(Settings{TLS: &disabled, TLSVerify: &disabled})
+	if flags := s.flags(); len(flags) != 4 {
+		t.Errorf("expected TLS to be disabled, got flags %v", flags)
+	}
+}
+
+func TestTLSDefaults(t *testing.T) {
+
+	// --- conditions----------------------------------------------------------
+	home, err := ioutil.TempDir("", "helm-home")
+	if err != nil {
+		t.Fatalf("unexpected error `%s`", err)
+	}
+	defer os.RemoveAll(home)
+	os.Setenv("HELM_HOME", home)
+	os.Setenv("HELM_TLS_VERIFY", "true")
+	os.Setenv("HELM_TLS_CA_CERT", filepath.Join(home, "tiller-ca.pem"))
+	defer os.Unsetenv("HELM_TLS_VERIFY")
+	defer os.Unsetenv("HELM_TLS_CA_CERT")
+
+	// --- call ---------------------------------------------------------------
+	s := Settings{TLSKey: "client.key"}.withTLSDefaults()
+
+	// --- test ---------------------------------------------------------------
+	expected := Settings{
+		TLSCaCert: filepath.Join(home, "tiller-ca.pem"),
+		TLSCert:   filepath.Join(home, "cert.pem"),
+		TLSKey:    "client.key",
+	}
+	if !s.tls() || !s.verify() {
+		t.Errorf("expected TLS to be enabled and verified")
+	}
+	files := s
+	files.TLS, files.TLSVerify = nil, nil
+	if files != expected {
+		t.Errorf("expected settings %+v, got %+v", expected, files)
+	}
+	if _, err := s.tlsConfig(); err == nil {
+		t.Errorf("expected an error on missing certificate files")
+	}
+	if cfg, err := (Settings{}).tlsConfig(); cfg != nil || err != nil {
+		t.Errorf("expected TLS to be disabled")
+	}
+}
+
+func TestEnvSettings(t *testing.T) {
+
+	// --- conditions----------------------------------------------------------
+	os.Setenv("HELM_KUBECONTEXT", "production")
+	os.Setenv("TILLER_NAMESPACE", defaultTillerNamespace)
+	defer os.Unsetenv("HELM_KUBECONTEXT")
+	defer os.Unsetenv("TILLER_NAMESPACE")
+	planSettings := Settings{KubeContext: "staging", TillerNamespace: "tiller"}
+
+	// --- call ---------------------------------------------------------------
+	s := planSettings.Merge(EnvSettings())
+
+	// --- test ---------------------------------------------------------------
+	expected := Settings{KubeContext: "production", TillerNamespace: "tiller"}
+	if s != expected {
+		t.Errorf("expected settings %+v, got %+v", expected, s)
+	}
+
+	os.Setenv("TILLER_NAMESPACE", "helm")
+	if s := EnvSettings(); s.TillerNamespace != "helm" {
+		t.Errorf("expected Tiller namespace helm, got `%s`", s.TillerNamespace)
+	}
+}
@@ -0,0 +1,103 @@
+package helm
+
+import (
+	"crypto/tls"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"k8s.io/helm/pkg/tlsutil"
+)
+
+// The TLS files looked up in $HELM_HOME when not specified
+const (
+	defaultTLSCaCert = "ca.pem"
+	defaultTLSCert   = "cert.pem"
+	defaultTLSKey    = "key.pem"
+)
+
+// envBool returns the boolean value of the environment variable, nil when
+// not set or invalid
+func envBool(name string) *bool {
+	v, err := strconv.ParseBool(os.Getenv(name))
+	if err != nil {
+		return nil
+	}
+	return &v
+}
+
+// withTLSDefaults returns the settings completed with the HELM_TLS_*
+// environment variables and, when TLS is enabled, the files of $HELM_HOME
+// for the files still unspecified
+func (s Settings) withTLSDefaults() Settings {
+	if s.TLS == nil {
+		s.TLS = envBool("HELM_TLS_ENABLE")
+	}
+	if s.TLSVerify == nil {
+		s.TLSVerify = envBool("HELM_TLS_VERIFY")
+	}
+	if !s.tls() {
+		return s
+	}
+
+	defaults := []struct {
+		file     *string
+		env      string
+		filename string
+	}{
+		{&s.TLSCaCert, "HELM_TLS_CA_CERT", defaultTLSCaCert},
+		{&s.TLSCert, "HELM_TLS_CERT", defaultTLSCert},
+		{&s.TLSKey, "HELM_TLS_KEY", defaultTLSKey},
+	}
+	for _, d := range defaults {
+		if *d.file != "" {
+			continue
+		}
+		*d.file = os.Getenv(d.env)
+		if *d.file == "" {
+			*d.file = filepath.Join(Home().String(), d.filename)
+		}
+	}
+	return s
+}
+
+// tlsConfig returns the TLS configuration of the client, nil when TLS is
+// disabled. The CA certificate is only required to verify Tiller.
+func (s Settings) tlsConfig() (*tls.Config, error) {
+	if !s.tls() {
+		return nil, nil
+	}
+
+	files := []struct {
+		path, flag, env string
+		required        bool
+	}{
+		{s.TLSCaCert, "--tls-ca-cert", "HELM_TLS_CA_CERT", s.verify()},
+		{s.TLSCert, "--tls-cert", "HELM_TLS_CERT", true},
+		{s.TLSKey, "--tls-key", "HELM_TLS_KEY", true},
+	}
+	for _, f := range files {
+		if !f.required {
+			continue
+		}
+		if _, err := os.Stat(f.path); err != nil {
+			return nil, fmt.Errorf("TLS file %s not found, specify it with %s or %s: %s", f.path, f.flag, f.env, err)
+		}
+	}
+
+	opts := tlsutil.Options{
+		CertFile:           s.TLSCert,
+		KeyFile:            s.TLSKey,
+		InsecureSkipVerify: true,
+	}
+	if s.verify() {
+		opts.CaCertFile = s.TLSCaCert
+		opts.InsecureSkipVerify = false
+	}
+	cfg, err := tlsutil.ClientConfig(opts)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid TLS configuration: %s", err)
+	}
+	return cfg, nil
+}
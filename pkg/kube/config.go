@@ -0,0 +1,52 @@
+package kube
+
+import (
+	"encoding/json"
+	"errors"
+)
+
+// ClusterInfo identifies the cluster targeted by the kube context in use
+type ClusterInfo struct {
+	// The name of the kube context
+	Context string
+	// The name of the cluster in the kubeconfig
+	Cluster string
+	// The address of the API server
+	Server string
+}
+
+// CurrentCluster returns the cluster targeted by the kube context in use,
+// read from the kubeconfig
+func CurrentCluster() (ClusterInfo, error) {
+
+	out, err := Output("config", "view", "--minify", "--output", "json")
+	if err != nil {
+		return ClusterInfo{}, err
+	}
+
+	var config struct {
+		CurrentContext string `json:"current-context"`
+		Clusters       []struct {
+			Name    string `json:"name"`
+			Cluster struct {
+				Server string `json:"server"`
+			} `json:"cluster"`
+		} `json:"clusters"`
+	}
+	if err := json.Unmarshal(out, &config); err != nil {
+		return ClusterInfo{}, err
+	}
+	if len(config.Clusters) == 0 {
+		return ClusterInfo{}, errors.New("No cluster found for the kube context")
+	}
+
+	info := ClusterInfo{
+		Context: config.CurrentContext,
+		Cluster: config.Clusters[0].Name,
+		Server:  config.Clusters[0].Cluster.Server,
+	}
+	if kubeContext != "" {
+		info.Context = kubeContext
+	}
+	return info, nil
+}
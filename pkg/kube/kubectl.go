@@ -0,0 +1,106 @@
+package kube
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/rodcloutier/helm-steer/pkg/executor"
+)
+
+// The kube context of the commands, the current one when empty
+var kubeContext string
+
+// SetContext sets the kube context used by every kubectl command
+func SetContext(name string) {
+	kubeContext = name
+}
+
+// args returns the arguments with the kube context
+func args(args []string) []string {
+	if kubeContext == "" {
+		return args
+	}
+	return append([]string{"--context", kubeContext}, args...)
+}
+
+// Command returns the kubectl command with the specified arguments
+func Command(a ...string) executor.Command {
+	return executor.NewExecutableCommand("kubectl", args(a))
+}
+
+// Output executes kubectl with the specified arguments and returns its
+// output
+func Output(args ...string) ([]byte, error) {
+	result, err := Command(args...).Run(ioutil.Discard)
+	return result.Stdout, err
+}
+
+// Get returns the object of the kind with the name, in the namespace if not
+// empty, or nil if it does not exist
+func Get(kind, namespace, name string) (map[string]interface{}, error) {
+	a := []string{"get", kind, name, "--ignore-not-found", "--output", "json"}
+	if namespace != "" {
+		a = append(a, "--namespace", namespace)
+	}
+	out, err := Output(a...)
+	if err != nil {
+		return nil, err
+	}
+	if len(bytes.TrimSpace(out)) == 0 {
+		return nil, nil
+	}
+	var object map[string]interface{}
+	if err := json.Unmarshal(out, &object); err != nil {
+		return nil, err
+	}
+	return object, nil
+}
+
+// manifestCommand is a kubectl command taking a manifest file
+type manifestCommand struct {
+	verb        string
+	description string
+	manifest    []byte
+}
+
+// NewApplyCommand returns a command applying the manifest with kubectl
+func NewApplyCommand(description string, manifest []byte) executor.Command {
+	return &manifestCommand{
+		verb:        "apply",
+		description: description,
+		manifest:    manifest,
+	}
+}
+
+// NewReplaceCommand returns a command replacing the object of the manifest
+// with kubectl. When the manifest specifies the resourceVersion of the
+// object, the command fails if the object was changed since.
+func NewReplaceCommand(description string, manifest []byte) executor.Command {
+	return &manifestCommand{
+		verb:        "replace",
+		description: description,
+		manifest:    manifest,
+	}
+}
+
+func (c manifestCommand) String() string {
+	return "kubectl " + c.verb + " " + c.description
+}
+
+func (c manifestCommand) Run(w io.Writer) (executor.Result, error) {
+	f, err := ioutil.TempFile("", "helm-steer-manifest")
+	if err != nil {
+		return executor.Result{}, err
+	}
+	defer os.Remove(f.Name())
+
+	_, err = f.Write(c.manifest)
+	f.Close()
+	if err != nil {
+		return executor.Result{}, err
+	}
+	return Command(c.verb, "--filename", f.Name()).Run(w)
+}
@@ -0,0 +1,51 @@
+package kube
+
+import (
+	"bufio"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strconv"
+)
+
+// forwardingLine is the line printed by kubectl once the port is forwarded
+var forwardingLine = regexp.MustCompile(`^Forwarding from 127\.0\.0\.1:(\d+) `)
+
+// PortForward forwards a random local port to the port of the pod with
+// kubectl. It returns the local address and a function stopping the
+// forwarding.
+func PortForward(namespace, pod string, port int) (string, func() error, error) {
+
+	cmd := exec.Command("kubectl", args([]string{"port-forward", pod, fmt.Sprintf(":%d", port), "--namespace", namespace})...)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return "", nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return "", nil, err
+	}
+	stop := func() error {
+		if err := cmd.Process.Kill(); err != nil {
+			return err
+		}
+		cmd.Wait()
+		return nil
+	}
+
+	scanner := bufio.NewScanner(stdout)
+	for scanner.Scan() {
+		m := forwardingLine.FindStringSubmatch(scanner.Text())
+		if m == nil {
+			continue
+		}
+		local, _ := strconv.Atoi(m[1])
+		// Keep reading the output so kubectl never blocks on it
+		go func() {
+			for scanner.Scan() {
+			}
+		}()
+		return fmt.Sprintf("127.0.0.1:%d", local), stop, nil
+	}
+	stop()
+	return "", nil, fmt.Errorf("Failed to forward a port to pod %s in namespace %s", pod, namespace)
+}
@@ -0,0 +1,45 @@
+package steer
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/rodcloutier/helm-steer/pkg/format"
+	"github.com/rodcloutier/helm-steer/pkg/helm"
+	"github.com/rodcloutier/helm-steer/pkg/plan"
+)
+
+// loadPlan loads the plan with its lock file, if any, and configures helm for
+// the cluster of the plan overridden by the settings. A plan expecting
+// another cluster is refused unless ignoreClusterIdentity is set, the
+// mismatch being then written to warnings.
+func loadPlan(planPath string, settings helm.Settings, ignoreClusterIdentity bool, warnings, debugWriter io.Writer) (*plan.Plan, error) {
+
+	pl, err := plan.Load(planPath)
+	if err != nil {
+		return nil, err
+	}
+
+	lf, err := plan.LoadLockFile(plan.LockPath(planPath))
+	if err != nil {
+		return nil, err
+	}
+	if lf != nil {
+		fmt.Fprintf(debugWriter, "Using lock file %s ...\n", plan.LockPath(planPath))
+		if err := pl.UseLock(lf); err != nil {
+			return nil, err
+		}
+	}
+
+	helm.Configure(pl.Cluster.Merge(settings))
+
+	// Applying a plan to the wrong cluster must be detected before anything
+	// is performed
+	if err := pl.VerifyCluster(); err != nil {
+		if !ignoreClusterIdentity {
+			return nil, err
+		}
+		fmt.Fprintln(warnings, format.Error("Warning: "+err.Error()))
+	}
+	return pl, nil
+}
@@ -0,0 +1,194 @@
+package lock
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rodcloutier/helm-steer/pkg/kube"
+)
+
+// errConflict reports that the ConfigMap was changed by another run
+var errConflict = errors.New("The lock was changed by another run")
+
+type configMapBackend struct {
+	namespace string
+}
+
+// NewConfigMapBackend returns a backend storing the locks as ConfigMaps
+// in the specified namespace, usually the one of Tiller. A released lock is
+// an empty ConfigMap: the changes of a lock are replacements of the
+// ConfigMap at the version read, failing if another run changed it since.
+func NewConfigMapBackend(namespace string) Backend {
+	return &configMapBackend{namespace: namespace}
+}
+
+func (b configMapBackend) name(namespace string) string {
+	return "helm-steer-lock-" + namespace
+}
+
+// configMap is the ConfigMap of a lock
+type configMap struct {
+	Metadata struct {
+		ResourceVersion string `json:"resourceVersion"`
+	} `json:"metadata"`
+	Data map[string]string `json:"data"`
+}
+
+// holder returns the holder of the lock, nil if the lock is released
+func (cm configMap) holder() *Info {
+	if len(cm.Data) == 0 {
+		return nil
+	}
+	pid, _ := strconv.Atoi(cm.Data["pid"])
+	acquired, _ := time.Parse(time.RFC3339, cm.Data["acquired"])
+	renewed, _ := time.Parse(time.RFC3339, cm.Data["renewed"])
+	return &Info{
+		ID:       cm.Data["id"],
+		Owner:    cm.Data["owner"],
+		PID:      pid,
+		Plan:     cm.Data["plan"],
+		Acquired: acquired,
+		Renewed:  renewed,
+	}
+}
+
+// data returns the ConfigMap data describing the holder
+func data(info Info) map[string]string {
+	d := map[string]string{
+		"id":       info.ID,
+		"owner":    info.Owner,
+		"pid":      strconv.Itoa(info.PID),
+		"plan":     info.Plan,
+		"acquired": info.Acquired.Format(time.RFC3339Nano),
+	}
+	if !info.Renewed.IsZero() {
+		d["renewed"] = info.Renewed.Format(time.RFC3339Nano)
+	}
+	return d
+}
+
+// get returns the ConfigMap of the lock, nil if it does not exist
+func (b configMapBackend) get(namespace string) (*configMap, error) {
+	out, err := kube.Output("get", "configmap", b.name(namespace), "--namespace", b.namespace, "--ignore-not-found", "--output", "json")
+	if err != nil {
+		return nil, err
+	}
+	if len(bytes.TrimSpace(out)) == 0 {
+		return nil, nil
+	}
+	var cm configMap
+	if err := json.Unmarshal(out, &cm); err != nil {
+		return nil, err
+	}
+	return &cm, nil
+}
+
+// replace replaces the data of the ConfigMap of the lock if it is still at
+// the resource version, errConflict otherwise
+func (b configMapBackend) replace(namespace, resourceVersion string, data map[string]string) error {
+	manifest, err := json.Marshal(map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "ConfigMap",
+		"metadata": map[string]string{
+			"name":            b.name(namespace),
+			"namespace":       b.namespace,
+			"resourceVersion": resourceVersion,
+		},
+		"data": data,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = kube.NewReplaceCommand("lock of namespace "+namespace, manifest).Run(ioutil.Discard)
+	// The error holds the kubectl stderr
+	if err != nil && (strings.Contains(err.Error(), "Conflict") || strings.Contains(err.Error(), "has been modified")) {
+		return errConflict
+	}
+	return err
+}
+
+func (b configMapBackend) TryLock(namespace string, info Info) (bool, *Info, error) {
+	for {
+		cm, err := b.get(namespace)
+		if err != nil {
+			return false, nil, err
+		}
+
+		if cm == nil {
+			// The creation fails if the ConfigMap already exists, making
+			// it atomic
+			args := []string{"create", "configmap", b.name(namespace), "--namespace", b.namespace}
+			d := data(info)
+			keys := []string{}
+			for k := range d {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			for _, k := range keys {
+				args = append(args, "--from-literal", k+"="+d[k])
+			}
+			_, err := kube.Output(args...)
+			if err == nil {
+				return true, nil, nil
+			}
+			if strings.Contains(err.Error(), "AlreadyExists") {
+				continue
+			}
+			return false, nil, err
+		}
+
+		if holder := cm.holder(); holder != nil {
+			return false, holder, nil
+		}
+		// The lock was released, take it unless another run did first
+		err = b.replace(namespace, cm.Metadata.ResourceVersion, data(info))
+		if err == errConflict {
+			continue
+		}
+		return err == nil, nil, err
+	}
+}
+
+func (b configMapBackend) Renew(namespace string, info Info) error {
+	cm, err := b.get(namespace)
+	if err != nil {
+		return err
+	}
+	if cm == nil || cm.holder() == nil || cm.holder().ID != info.ID {
+		return fmt.Errorf("The lock of namespace %s is no longer held", namespace)
+	}
+	if err := b.replace(namespace, cm.Metadata.ResourceVersion, data(info)); err != nil {
+		return fmt.Errorf("Failed to renew the lock of namespace %s: %s", namespace, err)
+	}
+	return nil
+}
+
+func (b configMapBackend) Unlock(namespace string, holder *Info) error {
+	if holder == nil {
+		_, err := kube.Command("delete", "configmap", b.name(namespace), "--namespace", b.namespace, "--ignore-not-found").Run(ioutil.Discard)
+		return err
+	}
+
+	cm, err := b.get(namespace)
+	if err != nil || cm == nil {
+		return err
+	}
+	current := cm.holder()
+	if current == nil {
+		return nil
+	}
+	if !current.unchangedSince(*holder) {
+		return fmt.Errorf("The lock of namespace %s is held by %s", namespace, current)
+	}
+	if err := b.replace(namespace, cm.Metadata.ResourceVersion, map[string]string{}); err != nil {
+		return fmt.Errorf("Failed to release the lock of namespace %s: %s", namespace, err)
+	}
+	return nil
+}
@@ -0,0 +1,158 @@
+package lock
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// The age after which a guard left by an interrupted run is removed
+var guardTimeout = 10 * time.Second
+
+type fileBackend struct {
+	dir string
+}
+
+// NewFileBackend returns a backend storing the locks as files in the
+// directory. It only protects runs performed on the same host.
+func NewFileBackend(dir string) Backend {
+	return &fileBackend{dir: dir}
+}
+
+func (b fileBackend) path(namespace string) string {
+	return filepath.Join(b.dir, namespace+".lock")
+}
+
+func (b fileBackend) TryLock(namespace string, info Info) (bool, *Info, error) {
+	if err := os.MkdirAll(b.dir, 0755); err != nil {
+		return false, nil, err
+	}
+
+	// The lock is linked in place once written, it is never seen empty. The
+	// link fails if the lock exists, making it atomic.
+	tmp, err := b.write(info)
+	if err != nil {
+		return false, nil, err
+	}
+	defer os.Remove(tmp)
+
+	err = os.Link(tmp, b.path(namespace))
+	if os.IsExist(err) {
+		holder, err := b.holder(namespace)
+		if err == nil && holder == nil {
+			// Released in the meantime
+			return b.TryLock(namespace, info)
+		}
+		return false, holder, err
+	}
+	return err == nil, nil, err
+}
+
+// write writes the lock content to a temporary file of the directory and
+// returns its path
+func (b fileBackend) write(info Info) (string, error) {
+	f, err := ioutil.TempFile(b.dir, ".lock")
+	if err != nil {
+		return "", err
+	}
+	err = json.NewEncoder(f).Encode(info)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// holder returns the holder of the lock, nil if the lock is not held
+func (b fileBackend) holder(namespace string) (*Info, error) {
+	content, err := ioutil.ReadFile(b.path(namespace))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var info Info
+	if err := json.Unmarshal(content, &info); err != nil {
+		// An unreadable lock is held by an unknown run, without
+		// identifier it is never broken
+		return &Info{Owner: "unknown"}, nil
+	}
+	return &info, nil
+}
+
+// guard serializes the changes to the lock of the namespace and returns the
+// function releasing the guard. A guard older than guardTimeout was left by
+// an interrupted run and is removed.
+func (b fileBackend) guard(namespace string) (func(), error) {
+	path := b.path(namespace) + ".guard"
+	for {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err == nil {
+			f.Close()
+			return func() { os.Remove(path) }, nil
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+		if fi, err := os.Stat(path); err == nil && time.Since(fi.ModTime()) > guardTimeout {
+			os.Remove(path)
+			continue
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func (b fileBackend) Renew(namespace string, info Info) error {
+	release, err := b.guard(namespace)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	current, err := b.holder(namespace)
+	if err != nil {
+		return err
+	}
+	if current == nil || current.ID != info.ID {
+		return fmt.Errorf("The lock of namespace %s is no longer held", namespace)
+	}
+	tmp, err := b.write(info)
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, b.path(namespace)); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+func (b fileBackend) Unlock(namespace string, holder *Info) error {
+	if holder != nil {
+		release, err := b.guard(namespace)
+		if err != nil {
+			return err
+		}
+		defer release()
+
+		current, err := b.holder(namespace)
+		if err != nil || current == nil {
+			return err
+		}
+		if !current.unchangedSince(*holder) {
+			return fmt.Errorf("The lock of namespace %s is held by %s", namespace, current)
+		}
+	}
+	err := os.Remove(b.path(namespace))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
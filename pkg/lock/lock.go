@@ -0,0 +1,181 @@
+package lock
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+)
+
+// The delay between two attempts to acquire a lock
+var retryInterval = 2 * time.Second
+
+// The delay between two renewals of the locks held, shortened to a third of
+// the stale age when it is lower
+var renewInterval = time.Minute
+
+// Info describes the holder of a lock
+type Info struct {
+	// The identifier of the run holding the lock
+	ID       string    `json:"id"`
+	Owner    string    `json:"owner"`
+	PID      int       `json:"pid"`
+	Plan     string    `json:"plan"`
+	Acquired time.Time `json:"acquired"`
+	// The last time the run renewed the lock, zero until it does
+	Renewed time.Time `json:"renewed"`
+}
+
+// String returns the string representation of the lock holder
+func (i Info) String() string {
+	return fmt.Sprintf("%s (pid %d, plan %s) since %s", i.Owner, i.PID, i.Plan, i.Acquired.Format(time.RFC3339))
+}
+
+// lastSeen returns the last time the holder was known to use the lock
+func (i Info) lastSeen() time.Time {
+	if i.Renewed.IsZero() {
+		return i.Acquired
+	}
+	return i.Renewed
+}
+
+// unchangedSince reports whether the lock is still held as described by
+// holder: by the same run and not renewed since
+func (i Info) unchangedSince(holder Info) bool {
+	return i.ID == holder.ID && !i.lastSeen().After(holder.lastSeen())
+}
+
+// Backend stores one lock per namespace
+type Backend interface {
+	// TryLock acquires the lock of the namespace. When the lock is already
+	// held, it returns false and the current holder.
+	TryLock(namespace string, info Info) (bool, *Info, error)
+	// Renew records the renewal of the lock of the namespace held by the
+	// run of info at info.Renewed. It returns an error if the lock is no
+	// longer held by the run.
+	Renew(namespace string, info Info) error
+	// Unlock releases the lock of the namespace if it is still held as
+	// described by holder, or whoever holds it if holder is nil. It
+	// returns an error if the lock is held by another run or was renewed.
+	Unlock(namespace string, holder *Info) error
+}
+
+// Lock is a set of namespaces locks held, renewed until they are released
+type Lock struct {
+	backend    Backend
+	info       Info
+	namespaces []string
+	stop       chan struct{}
+	done       chan struct{}
+}
+
+// NewInfo returns the information identifying the current process as the
+// holder of a lock for the plan
+func NewInfo(plan string) Info {
+	host, _ := os.Hostname()
+	user := os.Getenv("USER")
+	if user == "" {
+		user = os.Getenv("USERNAME")
+	}
+	id := make([]byte, 8)
+	rand.Read(id)
+	return Info{
+		ID:       hex.EncodeToString(id),
+		Owner:    fmt.Sprintf("%s@%s", user, host),
+		PID:      os.Getpid(),
+		Plan:     plan,
+		Acquired: time.Now().UTC(),
+	}
+}
+
+// Acquire locks the namespaces, waiting up to timeout for the locks held by
+// others. Locks not renewed for longer than staleAfter are considered stale
+// and are broken, unless their holder is unknown. On failure, the locks
+// already acquired are released. The locks acquired are renewed until they
+// are released.
+func Acquire(backend Backend, namespaces []string, info Info, timeout, staleAfter time.Duration) (*Lock, error) {
+
+	// Always lock in the same order to prevent dead locks between runs
+	sorted := append([]string{}, namespaces...)
+	sort.Strings(sorted)
+
+	l := &Lock{backend: backend, info: info}
+	deadline := time.Now().Add(timeout)
+	for _, ns := range sorted {
+		for {
+			ok, holder, err := backend.TryLock(ns, info)
+			if err != nil {
+				l.Release()
+				return nil, err
+			}
+			if ok {
+				l.namespaces = append(l.namespaces, ns)
+				break
+			}
+			// A lock without identifier is never broken, its holder
+			// cannot be told apart from another one
+			if staleAfter != 0 && holder.ID != "" && time.Since(holder.lastSeen()) > staleAfter {
+				fmt.Printf("Breaking stale lock on namespace %s held by %s\n", ns, holder)
+				if err := backend.Unlock(ns, holder); err != nil {
+					l.Release()
+					return nil, err
+				}
+				continue
+			}
+			if time.Now().After(deadline) {
+				l.Release()
+				return nil, fmt.Errorf("Namespace %s is locked by %s", ns, holder)
+			}
+			time.Sleep(retryInterval)
+		}
+	}
+
+	interval := renewInterval
+	if staleAfter != 0 && staleAfter/3 < interval {
+		interval = staleAfter / 3
+	}
+	l.stop = make(chan struct{})
+	l.done = make(chan struct{})
+	go l.renew(interval)
+	return l, nil
+}
+
+// renew renews the locks at every interval until the lock is released
+func (l *Lock) renew(interval time.Duration) {
+	defer close(l.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-l.stop:
+			return
+		case now := <-ticker.C:
+			l.info.Renewed = now.UTC()
+			for _, ns := range l.namespaces {
+				if err := l.backend.Renew(ns, l.info); err != nil {
+					fmt.Printf("Failed to renew the lock of namespace %s: %s\n", ns, err)
+				}
+			}
+		}
+	}
+}
+
+// Release releases all the namespaces locks still held. A lock broken as
+// stale and acquired by another run is left to it.
+func (l *Lock) Release() error {
+	if l.stop != nil {
+		close(l.stop)
+		<-l.done
+		l.stop = nil
+	}
+	var last error
+	for _, ns := range l.namespaces {
+		if err := l.backend.Unlock(ns, &l.info); err != nil {
+			last = err
+		}
+	}
+	l.namespaces = nil
+	return last
+}
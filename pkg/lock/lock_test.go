@@ -0,0 +1,178 @@
+package lock
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAcquireFileLock(t *testing.T) {
+
+	// --- conditions----------------------------------------------------------
+	dir, err := ioutil.TempDir("", "helm-steer-lock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(d time.Duration) { retryInterval = d }(retryInterval)
+	retryInterval = time.Millisecond
+
+	backend := NewFileBackend(dir)
+	namespaces := []string{"foo", "bar"}
+
+	// --- call ---------------------------------------------------------------
+	l, err := Acquire(backend, namespaces, NewInfo("plan.yaml"), 0, time.Hour)
+
+	// --- test ---------------------------------------------------------------
+	if err != nil {
+		t.Fatalf("unexpected error `%s`", err)
+	}
+
+	// A second run cannot lock any of the namespaces
+	_, err = Acquire(backend, []string{"bar"}, NewInfo("other.yaml"), 10*time.Millisecond, time.Hour)
+	if err == nil {
+		t.Errorf("expected namespace bar to be locked")
+	}
+
+	// Once released, the namespaces can be locked again
+	if err := l.Release(); err != nil {
+		t.Fatalf("unexpected error `%s`", err)
+	}
+	l, err = Acquire(backend, namespaces, NewInfo("other.yaml"), 0, time.Hour)
+	if err != nil {
+		t.Fatalf("expected the namespaces to be unlocked, got `%s`", err)
+	}
+	l.Release()
+}
+
+func TestAcquireStaleLock(t *testing.T) {
+
+	// --- conditions----------------------------------------------------------
+	dir, err := ioutil.TempDir("", "helm-steer-lock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	backend := NewFileBackend(dir)
+	stale := NewInfo("plan.yaml")
+	stale.Acquired = time.Now().Add(-2 * time.Hour)
+	if ok, _, err := backend.TryLock("foo", stale); !ok || err != nil {
+		t.Fatalf("failed to create the stale lock: %v", err)
+	}
+
+	// --- call ---------------------------------------------------------------
+	l, err := Acquire(backend, []string{"foo"}, NewInfo("plan.yaml"), 0, time.Hour)
+
+	// --- test ---------------------------------------------------------------
+	if err != nil {
+		t.Fatalf("expected the stale lock to be broken, got `%s`", err)
+	}
+	l.Release()
+}
+
+func TestReleaseBrokenLock(t *testing.T) {
+
+	// --- conditions----------------------------------------------------------
+	dir, err := ioutil.TempDir("", "helm-steer-lock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	backend := NewFileBackend(dir)
+	stale := NewInfo("plan.yaml")
+	stale.Acquired = time.Now().Add(-2 * time.Hour)
+	l, err := Acquire(backend, []string{"foo"}, stale, 0, 0)
+	if err != nil {
+		t.Fatalf("unexpected error `%s`", err)
+	}
+	// Another run breaks the stale lock and acquires it
+	info := NewInfo("plan.yaml")
+	other, err := Acquire(backend, []string{"foo"}, info, 0, time.Hour)
+	if err != nil {
+		t.Fatalf("expected the stale lock to be broken, got `%s`", err)
+	}
+
+	// --- call ---------------------------------------------------------------
+	err = l.Release()
+
+	// --- test ---------------------------------------------------------------
+	if err == nil {
+		t.Errorf("expected an error releasing a lock held by another run")
+	}
+	holder, err := backend.(*fileBackend).holder("foo")
+	if err != nil || holder == nil || holder.ID != info.ID {
+		t.Errorf("expected the lock to still be held by the other run, got %v", holder)
+	}
+	if err := other.Release(); err != nil {
+		t.Errorf("unexpected error `%s`", err)
+	}
+}
+
+func TestAcquireUnknownLock(t *testing.T) {
+
+	// --- conditions----------------------------------------------------------
+	dir, err := ioutil.TempDir("", "helm-steer-lock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	backend := NewFileBackend(dir)
+	// An undecodable lock, and an old lock without identifier
+	if err := ioutil.WriteFile(filepath.Join(dir, "foo.lock"), []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	anonymous := NewInfo("plan.yaml")
+	anonymous.ID = ""
+	anonymous.Acquired = time.Now().Add(-2 * time.Hour)
+	if ok, _, err := backend.TryLock("bar", anonymous); !ok || err != nil {
+		t.Fatalf("failed to create the lock: %v", err)
+	}
+
+	for _, ns := range []string{"foo", "bar"} {
+
+		// --- call -----------------------------------------------------------
+		_, err := Acquire(backend, []string{ns}, NewInfo("plan.yaml"), 0, time.Hour)
+
+		// --- test -----------------------------------------------------------
+		if err == nil {
+			t.Errorf("expected the lock of namespace %s not to be broken", ns)
+		}
+	}
+}
+
+func TestRenewLock(t *testing.T) {
+
+	// --- conditions----------------------------------------------------------
+	dir, err := ioutil.TempDir("", "helm-steer-lock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	backend := NewFileBackend(dir)
+	staleAfter := 60 * time.Millisecond
+
+	// --- call ---------------------------------------------------------------
+	l, err := Acquire(backend, []string{"foo"}, NewInfo("plan.yaml"), 0, staleAfter)
+	if err != nil {
+		t.Fatalf("unexpected error `%s`", err)
+	}
+	defer l.Release()
+	time.Sleep(3 * staleAfter)
+
+	// --- test ---------------------------------------------------------------
+	// The lock is renewed, it is not broken as stale
+	_, err = Acquire(backend, []string{"foo"}, NewInfo("other.yaml"), 0, staleAfter)
+	if err == nil {
+		t.Errorf("expected the renewed lock not to be broken")
+	}
+	holder, err := backend.(*fileBackend).holder("foo")
+	if err != nil || holder == nil || holder.Renewed.IsZero() {
+		t.Errorf("expected the lock to be renewed, got %v", holder)
+	}
+}
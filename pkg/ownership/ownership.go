@@ -0,0 +1,116 @@
+package ownership
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/rodcloutier/helm-steer/pkg/executor"
+	"github.com/rodcloutier/helm-steer/pkg/kube"
+)
+
+// The name of the ConfigMap holding the owners of the namespace releases
+const configMapName = "helm-steer-owners"
+
+// Store records the plan owning each release
+type Store interface {
+	// Owners returns the owner of the releases of the namespace by release
+	// name
+	Owners(namespace string) (map[string]string, error)
+	// SetOwner records the owner of the release
+	SetOwner(namespace, release, owner string) error
+	// RemoveOwner removes the record of the owner of the release
+	RemoveOwner(namespace, release string) error
+}
+
+type configMapStore struct{}
+
+// NewConfigMapStore returns a store that records the owners in a ConfigMap
+// of each namespace
+func NewConfigMapStore() Store {
+	return &configMapStore{}
+}
+
+func (s configMapStore) Owners(namespace string) (map[string]string, error) {
+	out, err := kube.Output("get", "configmap", configMapName, "--namespace", namespace, "--output", "json", "--ignore-not-found")
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get the releases owners of namespace %s: %s", namespace, err)
+	}
+	owners := map[string]string{}
+	if strings.TrimSpace(string(out)) == "" {
+		return owners, nil
+	}
+	var cm struct {
+		Data map[string]string `json:"data"`
+	}
+	if err := json.Unmarshal(out, &cm); err != nil {
+		return nil, err
+	}
+	for release, owner := range cm.Data {
+		owners[release] = owner
+	}
+	return owners, nil
+}
+
+func (s configMapStore) SetOwner(namespace, release, owner string) error {
+	patch, err := json.Marshal(map[string]map[string]string{"data": {release: owner}})
+	if err != nil {
+		return err
+	}
+	_, err = kube.Output("patch", "configmap", configMapName, "--namespace", namespace, "--type", "merge", "--patch", string(patch))
+	if err == nil {
+		return nil
+	}
+	// The error holds the kubectl stderr
+	if !strings.Contains(err.Error(), "NotFound") {
+		return err
+	}
+	_, err = kube.Command("create", "configmap", configMapName, "--namespace", namespace, "--from-literal", release+"="+owner).Run(ioutil.Discard)
+	return err
+}
+
+func (s configMapStore) RemoveOwner(namespace, release string) error {
+	patch, err := json.Marshal(map[string]map[string]*string{"data": {release: nil}})
+	if err != nil {
+		return err
+	}
+	_, err = kube.Output("patch", "configmap", configMapName, "--namespace", namespace, "--type", "merge", "--patch", string(patch))
+	if err != nil && !strings.Contains(err.Error(), "NotFound") {
+		return err
+	}
+	return nil
+}
+
+type setOwnerCommand struct {
+	store     Store
+	namespace string
+	release   string
+	owner     string
+}
+
+// NewSetOwnerCommand returns a command recording the owner of the release,
+// or removing its record when the owner is empty
+func NewSetOwnerCommand(store Store, namespace, release, owner string) executor.Command {
+	return &setOwnerCommand{
+		store:     store,
+		namespace: namespace,
+		release:   release,
+		owner:     owner,
+	}
+}
+
+func (c setOwnerCommand) String() string {
+	if c.owner == "" {
+		return fmt.Sprintf("remove the owner of release %s in namespace %s", c.release, c.namespace)
+	}
+	return fmt.Sprintf("record plan %s as owner of release %s in namespace %s", c.owner, c.release, c.namespace)
+}
+
+func (c setOwnerCommand) Run(w io.Writer) (executor.Result, error) {
+	if c.owner == "" {
+		return executor.Result{}, c.store.RemoveOwner(c.namespace, c.release)
+	}
+	return executor.Result{}, c.store.SetOwner(c.namespace, c.release, c.owner)
+}
@@ -0,0 +1,71 @@
+package plan
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/rodcloutier/helm-steer/pkg/executor"
+	"github.com/rodcloutier/helm-steer/pkg/helm"
+)
+
+// Check is a verification performed after a release operation succeeded.
+// Only one kind of check must be specified per entry.
+type Check struct {
+	Test    *TestCheck `json:"test"`
+	HTTP    *HTTPCheck `json:"http"`
+	Command []string   `json:"command"`
+}
+
+// TestCheck runs `helm test` on the release
+type TestCheck struct {
+	Cleanup bool `json:"cleanup"`
+	Timeout int  `json:"timeout"`
+}
+
+// HTTPCheck performs a GET on the url and expects the specified status
+type HTTPCheck struct {
+	URL     string `json:"url"`
+	Status  int    `json:"status"`
+	Timeout int    `json:"timeout"`
+}
+
+// command returns the executable command performing the check for the
+// release named name
+func (c Check) command(name string) (executor.Command, error) {
+
+	kinds := 0
+	if c.Test != nil {
+		kinds++
+	}
+	if c.HTTP != nil {
+		kinds++
+	}
+	if len(c.Command) != 0 {
+		kinds++
+	}
+	if kinds != 1 {
+		return nil, errors.New("A check must specify exactly one of test, http or command")
+	}
+
+	switch {
+	case c.Test != nil:
+		args := []string{"test", name}
+		if c.Test.Cleanup {
+			args = append(args, "--cleanup")
+		}
+		if c.Test.Timeout != 0 {
+			args = append(args, "--timeout", strconv.Itoa(c.Test.Timeout))
+		}
+		return helm.Command(args...), nil
+
+	case c.HTTP != nil:
+		if c.HTTP.URL == "" {
+			return nil, errors.New("An http check requires an url")
+		}
+		timeout := time.Duration(c.HTTP.Timeout) * time.Second
+		return executor.NewHTTPCommand(c.HTTP.URL, c.HTTP.Status, timeout), nil
+	}
+
+	return executor.NewExecutableCommand(c.Command[0], c.Command[1:]), nil
+}
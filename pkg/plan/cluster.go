@@ -0,0 +1,54 @@
+package plan
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/rodcloutier/helm-steer/pkg/helm"
+	"github.com/rodcloutier/helm-steer/pkg/kube"
+)
+
+// The function returning the cluster targeted by the kube context in use
+var currentCluster = kube.CurrentCluster
+
+// Cluster is the cluster the plan is deployed to, with the connection
+// settings and the identity expected from the kubeconfig
+type Cluster struct {
+	helm.Settings
+
+	// The name of the cluster in the kubeconfig
+	Name string `json:"name"`
+	// The address of the API server
+	APIServer string `json:"apiServer"`
+	// The name of the kube context
+	ExpectedContext string `json:"expectedContext"`
+}
+
+// VerifyCluster verifies that the kube context in use targets the cluster
+// expected by the plan
+func (p *Plan) VerifyCluster() error {
+
+	c := p.Cluster
+	if c.Name == "" && c.APIServer == "" && c.ExpectedContext == "" {
+		return nil
+	}
+
+	current, err := currentCluster()
+	if err != nil {
+		return fmt.Errorf("Failed to identify the cluster: %s", err)
+	}
+
+	mismatch := func(what, expected, actual string) error {
+		return fmt.Errorf("The plan expects the %s `%s` but the kube context `%s` has `%s`", what, expected, current.Context, actual)
+	}
+	if c.ExpectedContext != "" && c.ExpectedContext != current.Context {
+		return fmt.Errorf("The plan expects the kube context `%s` but `%s` is in use", c.ExpectedContext, current.Context)
+	}
+	if c.Name != "" && c.Name != current.Cluster {
+		return mismatch("cluster", c.Name, current.Cluster)
+	}
+	if c.APIServer != "" && strings.TrimSuffix(c.APIServer, "/") != strings.TrimSuffix(current.Server, "/") {
+		return mismatch("API server", c.APIServer, current.Server)
+	}
+	return nil
+}
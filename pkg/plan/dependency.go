@@ -0,0 +1,240 @@
+package plan
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/deckarep/golang-set"
+
+	"github.com/rodcloutier/helm-steer/pkg/executor"
+	"github.com/rodcloutier/helm-steer/pkg/readiness"
+)
+
+const (
+	// conditionOrdered only orders the operations
+	conditionOrdered = "ordered"
+	// conditionReady waits for the dependency resources to be ready
+	conditionReady = "ready"
+)
+
+// The checker used to wait for the dependencies to be ready
+var readinessChecker = readiness.NewKubeChecker()
+
+// Dependency is a release on which another release depends. It is specified
+// either by name only or as an object with a condition.
+type Dependency struct {
+	Name      string `json:"name"`
+	Condition string `json:"condition"`
+	// Timeout in seconds when waiting for the dependency to be ready
+	Timeout int `json:"timeout"`
+}
+
+// UnmarshalJSON accepts both the release name and the object forms
+func (d *Dependency) UnmarshalJSON(b []byte) error {
+	var name string
+	if err := json.Unmarshal(b, &name); err == nil {
+		*d = Dependency{Name: name}
+		return nil
+	}
+
+	// Use an alias to avoid recursing in UnmarshalJSON
+	type dependency Dependency
+	var dep dependency
+	if err := json.Unmarshal(b, &dep); err != nil {
+		return err
+	}
+	*d = Dependency(dep)
+
+	switch d.Condition {
+	case "", conditionOrdered, conditionReady:
+	default:
+		return fmt.Errorf("Unknown dependency condition `%s` for %s", d.Condition, d.Name)
+	}
+	return nil
+}
+
+// waitCommands returns the commands waiting for the dependencies of the
+// release that must be ready
+func (r Release) waitCommands(graph dependencyGraph) ([]executor.Command, error) {
+
+	releases := make(map[string]Release, len(graph))
+	for _, n := range graph {
+		releases[n.Name()] = n.(Release)
+	}
+
+	cmds := []executor.Command{}
+	for _, dep := range r.Depends {
+		if dep.Condition != conditionReady {
+			continue
+		}
+		parent, ok := releases[dep.Name]
+		if !ok {
+			return nil, fmt.Errorf("Unknown dependency %s for %s", dep.Name, r.Name())
+		}
+		timeout := time.Duration(dep.Timeout) * time.Second
+		cmds = append(cmds, readiness.NewWaitCommand(readinessChecker, parent.Spec.namespace, parent.Name(), timeout))
+	}
+	return cmds, nil
+}
+
+// checkDependenciesDeployed ensures the dependencies of the releases to
+// install or upgrade are not left uninstalled: a dependency not deployed must
+// be selected as well. The operations would otherwise wait for a release
+// that is never installed.
+func checkDependenciesDeployed(selected, notDeployed mapset.Set, releases map[string]Release) error {
+	byName := map[string]string{}
+	for key, r := range releases {
+		byName[r.Name()] = key
+	}
+	for _, key := range sortedNames(selected) {
+		r := releases[key]
+		if r.action == actionNone {
+			continue
+		}
+		for _, dep := range r.Deps() {
+			if parent, ok := byName[dep]; ok && notDeployed.Contains(parent) && !selected.Contains(parent) {
+				return fmt.Errorf("Dependency %s of %s is neither selected nor deployed", dep, r.Name())
+			}
+		}
+	}
+	return nil
+}
+
+// dependencyError reports the dependencies preventing the ordering of the
+// releases
+type dependencyError struct {
+	// The dependencies on releases not in the graph, by release
+	dangling map[string][]string
+	// The cycles, as the path from a release through its dependencies back
+	// to itself
+	cycles [][]string
+}
+
+func (e dependencyError) Error() string {
+	names := []string{}
+	for name := range e.dangling {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	msgs := []string{}
+	for _, name := range names {
+		msgs = append(msgs, fmt.Sprintf("Unknown dependency %s of %s (not in the plan or in a namespace not targeted)",
+			strings.Join(e.dangling[name], ", "), name))
+	}
+	for _, cycle := range e.cycles {
+		msgs = append(msgs, "Circular dependency found: "+strings.Join(cycle, " -> "))
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// findCycles returns a cycle of each strongly connected component of the
+// dependencies holding more than one node, or a node depending on itself.
+// The components are found with Tarjan's algorithm.
+func findCycles(dependencies map[string]mapset.Set) [][]string {
+
+	// The sorted names and dependencies make the cycles reported stable
+	names := []string{}
+	deps := map[string][]string{}
+	for name, set := range dependencies {
+		names = append(names, name)
+		for dep := range set.Iter() {
+			deps[name] = append(deps[name], dep.(string))
+		}
+		sort.Strings(deps[name])
+	}
+	sort.Strings(names)
+
+	index := 0
+	indexes := map[string]int{}
+	lowLinks := map[string]int{}
+	onStack := map[string]bool{}
+	stack := []string{}
+	components := [][]string{}
+
+	var connect func(string)
+	connect = func(name string) {
+		indexes[name] = index
+		lowLinks[name] = index
+		index++
+		stack = append(stack, name)
+		onStack[name] = true
+
+		for _, dep := range deps[name] {
+			if _, visited := indexes[dep]; !visited {
+				connect(dep)
+				if lowLinks[dep] < lowLinks[name] {
+					lowLinks[name] = lowLinks[dep]
+				}
+			} else if onStack[dep] && indexes[dep] < lowLinks[name] {
+				lowLinks[name] = indexes[dep]
+			}
+		}
+
+		if lowLinks[name] != indexes[name] {
+			return
+		}
+		component := []string{}
+		for {
+			n := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			onStack[n] = false
+			component = append(component, n)
+			if n == name {
+				break
+			}
+		}
+		components = append(components, component)
+	}
+
+	for _, name := range names {
+		if _, visited := indexes[name]; !visited {
+			connect(name)
+		}
+	}
+
+	cycles := [][]string{}
+	for _, component := range components {
+		sort.Strings(component)
+		if len(component) == 1 && !dependencies[component[0]].Contains(component[0]) {
+			continue
+		}
+		cycles = append(cycles, cyclePath(component, deps))
+	}
+	sort.Slice(cycles, func(i, j int) bool { return cycles[i][0] < cycles[j][0] })
+	return cycles
+}
+
+// cyclePath returns a path from the first node of the strongly connected
+// component back to itself
+func cyclePath(component []string, deps map[string][]string) []string {
+
+	inComponent := map[string]bool{}
+	for _, name := range component {
+		inComponent[name] = true
+	}
+	start := component[0]
+	visited := map[string]bool{}
+
+	var walk func(path []string) []string
+	walk = func(path []string) []string {
+		name := path[len(path)-1]
+		for _, dep := range deps[name] {
+			if dep == start {
+				return append(path, start)
+			}
+			if !inComponent[dep] || visited[dep] {
+				continue
+			}
+			visited[dep] = true
+			if cycle := walk(append(path, dep)); cycle != nil {
+				return cycle
+			}
+		}
+		return nil
+	}
+	return walk([]string{start})
+}
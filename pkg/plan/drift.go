@@ -0,0 +1,243 @@
+package plan
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/Masterminds/semver"
+	"k8s.io/helm/pkg/proto/hapi/release"
+
+	"github.com/rodcloutier/helm-steer/pkg/helm"
+)
+
+// The kinds of drift between the plan and the deployed releases
+const (
+	// DriftMissing is a release of the plan that is not deployed
+	DriftMissing = "missing"
+	// DriftExtra is a release deployed in a namespace of the plan that is
+	// not in the plan
+	DriftExtra = "extra"
+	// DriftChart is a release deployed from another chart
+	DriftChart = "chart"
+	// DriftVersion is a release deployed with another chart version, or
+	// another content for the local charts
+	DriftVersion = "version"
+	// DriftValues is a release deployed with other values
+	DriftValues = "values"
+	// DriftFailed is a release whose last operation failed
+	DriftFailed = "failed"
+)
+
+// Drift is a difference between the plan and a deployed release
+type Drift struct {
+	Kind     string `json:"kind"`
+	Expected string `json:"expected,omitempty"`
+	Actual   string `json:"actual,omitempty"`
+	// The top level keys of the values that differ. The values themselves
+	// are not reported, they may be secrets.
+	Keys []string `json:"keys,omitempty"`
+}
+
+// String returns the string representation of a Drift
+func (d Drift) String() string {
+	if len(d.Keys) != 0 {
+		return fmt.Sprintf("%s: %s differ", d.Kind, strings.Join(d.Keys, ", "))
+	}
+	if d.Expected == "" && d.Actual == "" {
+		return d.Kind
+	}
+	return fmt.Sprintf("%s: expected %s, deployed %s", d.Kind, d.Expected, d.Actual)
+}
+
+// ReleaseDrift are the drifts of a release, none when it is up to date
+type ReleaseDrift struct {
+	Namespace string  `json:"namespace"`
+	Release   string  `json:"release"`
+	Drifts    []Drift `json:"drifts"`
+}
+
+// DriftReport is the comparison of the plan with the deployed releases
+type DriftReport struct {
+	// The releases compared, sorted by namespace and name
+	Releases []ReleaseDrift `json:"releases"`
+}
+
+// Drifted reports whether a release drifted from the plan
+func (r DriftReport) Drifted() bool {
+	return len(r.DriftedReleases()) != 0
+}
+
+// DriftedReleases returns the releases that drifted from the plan
+func (r DriftReport) DriftedReleases() []ReleaseDrift {
+	drifted := []ReleaseDrift{}
+	for _, rd := range r.Releases {
+		if len(rd.Drifts) != 0 {
+			drifted = append(drifted, rd)
+		}
+	}
+	return drifted
+}
+
+// Drift compares the releases of the targeted namespaces (empty is all
+// namespaces) to the deployed ones, without changing anything
+func (p *Plan) Drift(namespaces []string, deployed []*release.Release) (DriftReport, error) {
+
+	isValidNamespace := namespaceFilter(namespaces)
+	specified, specifiedMap := p.specifiedReleases(isValidNamespace)
+	current, currentMap := p.currentReleases(isValidNamespace, deployed)
+
+	report := DriftReport{}
+	for _, r := range specified.Union(current).ToSlice() {
+		key := r.(string)
+		rd := ReleaseDrift{}
+
+		d, ok := currentMap[key]
+		spec, specifiedOk := specifiedMap[key]
+		switch {
+		case !specifiedOk:
+			if d.GetInfo().GetStatus().GetCode() == release.Status_DELETED {
+				continue
+			}
+			rd.Namespace, rd.Release = d.Namespace, d.Name
+			rd.Drifts = []Drift{{Kind: DriftExtra}}
+		case !ok || d.GetInfo().GetStatus().GetCode() == release.Status_DELETED:
+			rd.Namespace, rd.Release = spec.Spec.namespace, spec.Name()
+			rd.Drifts = []Drift{{Kind: DriftMissing}}
+		default:
+			rd.Namespace, rd.Release = spec.Spec.namespace, spec.Name()
+			drifts, err := releaseDrifts(spec.Spec, d)
+			if err != nil {
+				return report, fmt.Errorf("Failed to compare release %s: %s", key, err)
+			}
+			rd.Drifts = drifts
+		}
+		report.Releases = append(report.Releases, rd)
+	}
+
+	sort.Slice(report.Releases, func(i, j int) bool {
+		a, b := report.Releases[i], report.Releases[j]
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		return a.Release < b.Release
+	})
+	return report, nil
+}
+
+// releaseDrifts returns the differences between the spec and the deployed
+// release
+func releaseDrifts(spec ReleaseSpec, deployed *release.Release) ([]Drift, error) {
+
+	drifts := []Drift{}
+
+	if deployed.GetInfo().GetStatus().GetCode() == release.Status_FAILED {
+		drifts = append(drifts, Drift{Kind: DriftFailed})
+	}
+
+	if deployedName, chartName, changed := chartChange(spec, deployed); changed {
+		drifts = append(drifts, Drift{Kind: DriftChart, Expected: chartName, Actual: deployedName})
+	}
+
+	drift, err := versionDrift(spec, deployed)
+	if err != nil {
+		return nil, err
+	}
+	if drift != nil {
+		drifts = append(drifts, *drift)
+	}
+
+	expected, err := helm.Values(spec.Flags.Upgrade.Values, spec.Flags.Upgrade.Set)
+	if err != nil {
+		return nil, err
+	}
+	actual := map[string]interface{}{}
+	if deployed.Config != nil {
+		if actual, err = helm.ParseValues(deployed.Config.Raw); err != nil {
+			return nil, err
+		}
+	}
+	if keys := differentValues(expected, actual); len(keys) != 0 {
+		drifts = append(drifts, Drift{Kind: DriftValues, Keys: keys})
+	}
+
+	return drifts, nil
+}
+
+// versionDrift returns the version drift of the deployed release, nil if the
+// deployed version is the locked or specified one, or satisfies the
+// specified range. Any version is accepted when none is specified.
+func versionDrift(spec ReleaseSpec, deployed *release.Release) (*Drift, error) {
+
+	if deployed.Chart == nil || deployed.Chart.Metadata == nil {
+		return nil, nil
+	}
+	deployedVersion := deployed.Chart.Metadata.Version
+
+	// A local chart drifts when its content changed, even if its version
+	// did not
+	if spec.isLocal() {
+		if err := spec.loadLocalChart(); err != nil {
+			return nil, err
+		}
+		if err := spec.digestLocalChart(); err != nil {
+			return nil, err
+		}
+		digest := helm.ChartDigest(deployed.Chart)
+		if digest == spec.localDigest {
+			return nil, nil
+		}
+		return &Drift{
+			Kind:     DriftVersion,
+			Expected: fmt.Sprintf("%s (%s)", spec.Version(), shortDigest(spec.localDigest)),
+			Actual:   fmt.Sprintf("%s (%s)", deployedVersion, shortDigest(digest)),
+		}, nil
+	}
+
+	version := spec.Version()
+	if version == "" || version == deployedVersion {
+		return nil, nil
+	}
+	// An exact version, like the ones pinned by the lock file, is the one
+	// expected
+	if _, err := semver.NewVersion(version); err == nil {
+		return &Drift{Kind: DriftVersion, Expected: version, Actual: deployedVersion}, nil
+	}
+
+	rangeConstraint, err := semver.NewConstraint(version)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid version range `%s`: %s", version, err)
+	}
+	deployedSemver, err := semver.NewVersion(deployedVersion)
+	if err != nil || !rangeConstraint.Check(deployedSemver) {
+		return &Drift{Kind: DriftVersion, Expected: version, Actual: deployedVersion}, nil
+	}
+	return nil, nil
+}
+
+// differentValues returns the sorted top level keys whose values differ
+func differentValues(a, b map[string]interface{}) []string {
+	keys := []string{}
+	for k, v := range a {
+		if !reflect.DeepEqual(v, b[k]) {
+			keys = append(keys, k)
+		}
+	}
+	for k := range b {
+		if _, ok := a[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// shortDigest returns the first characters of a digest
+func shortDigest(digest string) string {
+	digest = strings.TrimPrefix(digest, "sha256:")
+	if len(digest) > 12 {
+		return digest[:12]
+	}
+	return digest
+}
@@ -0,0 +1,187 @@
+package plan
+
+import (
+	"fmt"
+
+	"github.com/rodcloutier/helm-steer/pkg/executor"
+	"github.com/rodcloutier/helm-steer/pkg/helm"
+)
+
+// The executors performing the release operations
+const (
+	// ExecutorCLI runs the helm binary
+	ExecutorCLI = "cli"
+	// ExecutorLibrary calls Tiller with the Helm client
+	ExecutorLibrary = "library"
+)
+
+// releaseExecutor builds the commands performing the release operations
+type releaseExecutor interface {
+	install(r ReleaseSpec) (executor.Command, error)
+	upgrade(r ReleaseSpec) (executor.Command, error)
+	rollback(r ReleaseSpec, revision int32) (executor.Command, error)
+	delete(r ReleaseSpec) (executor.Command, error)
+}
+
+// newReleaseExecutor returns the named executor, the CLI one by default
+func newReleaseExecutor(name string) (releaseExecutor, error) {
+	switch name {
+	case "", ExecutorCLI:
+		return cliExecutor{}, nil
+	case ExecutorLibrary:
+		return libraryExecutor{}, nil
+	}
+	return nil, fmt.Errorf("Unknown executor `%s`", name)
+}
+
+type cliExecutor struct{}
+
+func (cliExecutor) install(r ReleaseSpec) (executor.Command, error) {
+	return helm.ReleaseCommand(r.name, r.installCmd()...), nil
+}
+
+func (cliExecutor) upgrade(r ReleaseSpec) (executor.Command, error) {
+	return helm.ReleaseCommand(r.name, r.upgradeCmd()...), nil
+}
+
+func (cliExecutor) rollback(r ReleaseSpec, revision int32) (executor.Command, error) {
+	return helm.ReleaseCommand(r.name, r.rollbackCmd(revision)...), nil
+}
+
+func (cliExecutor) delete(r ReleaseSpec) (executor.Command, error) {
+	return helm.ReleaseCommand(r.name, r.deleteCmd()...), nil
+}
+
+// libraryFlags are the flags the library executor honours, per command.
+// The other flags, the connection to Tiller among them, cannot be specified:
+// the connection is configured by the cluster settings.
+var libraryFlags = map[string]map[string]bool{
+	"install": {
+		"dry-run": true, "keyring": true, "name": true, "namespace": true,
+		"no-hooks": true, "replace": true, "set": true, "timeout": true,
+		"values": true, "verify": true, "version": true, "wait": true,
+	},
+	"upgrade": {
+		"dry-run": true, "force": true, "keyring": true, "namespace": true,
+		"no-hooks": true, "recreate-pods": true, "reset-values": true,
+		"reuse-values": true, "set": true, "timeout": true, "values": true,
+		"verify": true, "version": true, "wait": true,
+	},
+	"rollback": {
+		"dry-run": true, "force": true, "no-hooks": true, "recreate-pods": true,
+		"timeout": true, "wait": true,
+	},
+	"delete": {
+		"dry-run": true, "no-hooks": true, "purge": true, "timeout": true,
+	},
+}
+
+// verifyLibraryFlags returns an error if one of the specified flags is not
+// supported by the library executor. The extra arguments are never
+// supported.
+func verifyLibraryFlags(name, command string, flags []flag, extraArgs []string) error {
+	for _, f := range flags {
+		if len(f.args) != 0 && !libraryFlags[command][f.name] {
+			return fmt.Errorf("The %s flag %s of %s is not supported by the library executor", command, f.name, name)
+		}
+	}
+	if len(extraArgs) != 0 {
+		return fmt.Errorf("The %s flag extraArgs of %s is not supported by the library executor", command, name)
+	}
+	return nil
+}
+
+// The timeout of the helm commands in seconds, used by the library executor
+// when none is specified
+const defaultTimeout = 300
+
+// timeout returns the timeout in seconds, the helm default when unset
+func timeout(s Seconds) int64 {
+	if s == 0 {
+		return defaultTimeout
+	}
+	return int64(s)
+}
+
+type libraryExecutor struct{}
+
+func (libraryExecutor) install(r ReleaseSpec) (executor.Command, error) {
+	f := r.Flags.Install
+	if err := verifyLibraryFlags(r.name, "install", f.flags(), f.ExtraArgs); err != nil {
+		return nil, err
+	}
+	return helm.NewInstallCommand(helm.InstallOptions{
+		ChartOptions: helm.ChartOptions{
+			Chart:   r.chartRef(),
+			Version: f.Version,
+			Verify:  f.Verify,
+			Keyring: f.Keyring,
+			Values:  f.Values,
+			Set:     f.Set,
+		},
+		Name:         f.Name,
+		Namespace:    f.Namespace,
+		DryRun:       f.DryRun,
+		DisableHooks: f.NoHooks,
+		Replace:      f.Replace,
+		Wait:         f.Wait,
+		Timeout:      timeout(f.Timeout),
+	}), nil
+}
+
+func (libraryExecutor) upgrade(r ReleaseSpec) (executor.Command, error) {
+	f := r.Flags.Upgrade
+	if err := verifyLibraryFlags(r.name, "upgrade", f.flags(), f.ExtraArgs); err != nil {
+		return nil, err
+	}
+	return helm.NewUpgradeCommand(helm.UpgradeOptions{
+		ChartOptions: helm.ChartOptions{
+			Chart:   r.chartRef(),
+			Version: f.Version,
+			Verify:  f.Verify,
+			Keyring: f.Keyring,
+			Values:  f.Values,
+			Set:     f.Set,
+		},
+		Name:         r.name,
+		DryRun:       f.DryRun,
+		DisableHooks: f.NoHooks,
+		Force:        f.Force,
+		Recreate:     f.RecreatePods,
+		ResetValues:  f.ResetValues,
+		ReuseValues:  f.ReuseValues,
+		Wait:         f.Wait,
+		Timeout:      timeout(f.Timeout),
+	}), nil
+}
+
+func (libraryExecutor) rollback(r ReleaseSpec, revision int32) (executor.Command, error) {
+	f := r.Flags.Rollback
+	if err := verifyLibraryFlags(r.name, "rollback", f.flags(), f.ExtraArgs); err != nil {
+		return nil, err
+	}
+	return helm.NewRollbackCommand(helm.RollbackOptions{
+		Name:         r.name,
+		Revision:     revision,
+		DryRun:       f.DryRun,
+		DisableHooks: f.NoHooks,
+		Force:        f.Force,
+		Recreate:     f.RecreatePods,
+		Wait:         f.Wait,
+		Timeout:      timeout(f.Timeout),
+	}), nil
+}
+
+func (libraryExecutor) delete(r ReleaseSpec) (executor.Command, error) {
+	f := r.Flags.Delete
+	if err := verifyLibraryFlags(r.name, "delete", f.flags(), f.ExtraArgs); err != nil {
+		return nil, err
+	}
+	return helm.NewDeleteCommand(helm.DeleteOptions{
+		Name:         r.name,
+		DryRun:       f.DryRun,
+		DisableHooks: f.NoHooks,
+		Purge:        f.Purge,
+		Timeout:      timeout(f.Timeout),
+	}), nil
+}
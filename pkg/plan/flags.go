@@ -0,0 +1,350 @@
+package plan
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/Masterminds/semver"
+)
+
+// Seconds is a duration in seconds, specified either as a number of seconds
+// or as a duration string such as 5m
+type Seconds int64
+
+func (s *Seconds) UnmarshalJSON(b []byte) error {
+	var n int64
+	if err := json.Unmarshal(b, &n); err == nil {
+		*s = Seconds(n)
+		return nil
+	}
+	var str string
+	if err := json.Unmarshal(b, &str); err != nil {
+		return fmt.Errorf("Invalid duration %s", b)
+	}
+	d, err := time.ParseDuration(str)
+	if err != nil {
+		return fmt.Errorf("Invalid duration `%s`: %s", str, err)
+	}
+	*s = Seconds(d / time.Second)
+	return nil
+}
+
+// flag is a helm command flag with the arguments it emits, none when unset
+type flag struct {
+	name string
+	args []string
+}
+
+func boolFlag(name string, v bool) flag {
+	if !v {
+		return flag{name: name}
+	}
+	return flag{name, []string{"--" + name}}
+}
+
+func stringFlag(name string, v string) flag {
+	if v == "" {
+		return flag{name: name}
+	}
+	return flag{name, []string{"--" + name, v}}
+}
+
+func stringsFlag(name string, values []string) flag {
+	f := flag{name: name}
+	for _, v := range values {
+		if v != "" {
+			f.args = append(f.args, "--"+name, v)
+		}
+	}
+	return f
+}
+
+func secondsFlag(name string, v Seconds) flag {
+	if v == 0 {
+		return flag{name: name}
+	}
+	return flag{name, []string{"--" + name, strconv.FormatInt(int64(v), 10)}}
+}
+
+// flagArgs returns the arguments emitted by the flags followed by the extra
+// arguments
+func flagArgs(flags []flag, extraArgs []string) []string {
+	args := []string{}
+	for _, f := range flags {
+		args = append(args, f.args...)
+	}
+	return append(args, extraArgs...)
+}
+
+// flagVersions are the helm versions that introduced the flags, per
+// command. The flags not listed exist in every helm 2 version.
+var flagVersions = map[string]map[string]string{
+	"install": {
+		"devel":       "2.3.0",
+		"repo":        "2.4.0",
+		"wait":        "2.2.0",
+		"tls":         "2.5.0",
+		"tls-ca-cert": "2.5.0",
+		"tls-cert":    "2.5.0",
+		"tls-key":     "2.5.0",
+		"tls-verify":  "2.5.0",
+		"ca-file":     "2.5.0",
+		"cert-file":   "2.5.0",
+		"key-file":    "2.5.0",
+	},
+	"upgrade": {
+		"devel":        "2.3.0",
+		"repo":         "2.4.0",
+		"wait":         "2.2.0",
+		"reset-values": "2.3.0",
+		"reuse-values": "2.2.0",
+		"force":        "2.5.0",
+		"tls":          "2.5.0",
+		"tls-ca-cert":  "2.5.0",
+		"tls-cert":     "2.5.0",
+		"tls-key":      "2.5.0",
+		"tls-verify":   "2.5.0",
+		"ca-file":      "2.5.0",
+		"cert-file":    "2.5.0",
+		"key-file":     "2.5.0",
+	},
+	"rollback": {
+		"wait":        "2.2.0",
+		"force":       "2.5.0",
+		"tls":         "2.5.0",
+		"tls-ca-cert": "2.5.0",
+		"tls-cert":    "2.5.0",
+		"tls-key":     "2.5.0",
+		"tls-verify":  "2.5.0",
+	},
+	"delete": {
+		"tls":         "2.5.0",
+		"tls-ca-cert": "2.5.0",
+		"tls-cert":    "2.5.0",
+		"tls-key":     "2.5.0",
+		"tls-verify":  "2.5.0",
+	},
+}
+
+// verifyFlags returns an error if one of the specified flags does not exist
+// in the helm version
+func verifyFlags(command string, flags []flag, version *semver.Version) error {
+	for _, f := range flags {
+		if len(f.args) == 0 {
+			continue
+		}
+		since, ok := flagVersions[command][f.name]
+		if !ok {
+			continue
+		}
+		if v, _ := semver.NewVersion(since); version.LessThan(v) {
+			return fmt.Errorf("The %s flag %s requires helm %s or later, found %s", command, f.name, since, version)
+		}
+	}
+	return nil
+}
+
+type InstallFlags struct {
+	CAFile       string   `json:"ca-file"`
+	CertFile     string   `json:"cert-file"`
+	Devel        bool     `json:"devel"`
+	DryRun       bool     `json:"dry-run"`
+	KeyFile      string   `json:"key-file"`
+	Keyring      string   `json:"keyring"`
+	Name         string   `json:"name"`
+	NameTemplate string   `json:"name-template"`
+	Namespace    string   `json:"namespace"`
+	NoHooks      bool     `json:"no-hooks"`
+	Replace      bool     `json:"replace"`
+	Repo         string   `json:"repo"`
+	Set          []string `json:"set"`
+	Timeout      Seconds  `json:"timeout"`
+	TLS          bool     `json:"tls"`
+	TLSCACert    string   `json:"tls-ca-cert"`
+	TLSCert      string   `json:"tls-cert"`
+	TLSKey       string   `json:"tls-key"`
+	TLSVerify    bool     `json:"tls-verify"`
+	Values       []string `json:"values"`
+	Verify       bool     `json:"verify"`
+	Version      string   `json:"version"`
+	Wait         bool     `json:"wait"`
+	// Arguments passed as is to helm, for the flags not modeled
+	ExtraArgs []string `json:"extraArgs"`
+}
+
+func (f InstallFlags) flags() []flag {
+	return []flag{
+		stringFlag("ca-file", f.CAFile),
+		stringFlag("cert-file", f.CertFile),
+		boolFlag("devel", f.Devel),
+		boolFlag("dry-run", f.DryRun),
+		stringFlag("key-file", f.KeyFile),
+		stringFlag("keyring", f.Keyring),
+		stringFlag("name", f.Name),
+		stringFlag("name-template", f.NameTemplate),
+		stringFlag("namespace", f.Namespace),
+		boolFlag("no-hooks", f.NoHooks),
+		boolFlag("replace", f.Replace),
+		stringFlag("repo", f.Repo),
+		stringsFlag("set", f.Set),
+		secondsFlag("timeout", f.Timeout),
+		boolFlag("tls", f.TLS),
+		stringFlag("tls-ca-cert", f.TLSCACert),
+		stringFlag("tls-cert", f.TLSCert),
+		stringFlag("tls-key", f.TLSKey),
+		boolFlag("tls-verify", f.TLSVerify),
+		stringsFlag("values", f.Values),
+		boolFlag("verify", f.Verify),
+		stringFlag("version", f.Version),
+		boolFlag("wait", f.Wait),
+	}
+}
+
+type UpgradeFlags struct {
+	CAFile       string   `json:"ca-file"`
+	CertFile     string   `json:"cert-file"`
+	Devel        bool     `json:"devel"`
+	DryRun       bool     `json:"dry-run"`
+	Force        bool     `json:"force"`
+	Install      bool     `json:"install"`
+	KeyFile      string   `json:"key-file"`
+	Keyring      string   `json:"keyring"`
+	Namespace    string   `json:"namespace"`
+	NoHooks      bool     `json:"no-hooks"`
+	RecreatePods bool     `json:"recreate-pods"`
+	Repo         string   `json:"repo"`
+	ResetValues  bool     `json:"reset-values"`
+	ReuseValues  bool     `json:"reuse-values"`
+	Set          []string `json:"set"`
+	Timeout      Seconds  `json:"timeout"`
+	TLS          bool     `json:"tls"`
+	TLSCACert    string   `json:"tls-ca-cert"`
+	TLSCert      string   `json:"tls-cert"`
+	TLSKey       string   `json:"tls-key"`
+	TLSVerify    bool     `json:"tls-verify"`
+	Values       []string `json:"values"`
+	Verify       bool     `json:"verify"`
+	Version      string   `json:"version"`
+	Wait         bool     `json:"wait"`
+	// Arguments passed as is to helm, for the flags not modeled
+	ExtraArgs []string `json:"extraArgs"`
+}
+
+func (f UpgradeFlags) flags() []flag {
+	return []flag{
+		stringFlag("ca-file", f.CAFile),
+		stringFlag("cert-file", f.CertFile),
+		boolFlag("devel", f.Devel),
+		boolFlag("dry-run", f.DryRun),
+		boolFlag("force", f.Force),
+		boolFlag("install", f.Install),
+		stringFlag("key-file", f.KeyFile),
+		stringFlag("keyring", f.Keyring),
+		stringFlag("namespace", f.Namespace),
+		boolFlag("no-hooks", f.NoHooks),
+		boolFlag("recreate-pods", f.RecreatePods),
+		stringFlag("repo", f.Repo),
+		boolFlag("reset-values", f.ResetValues),
+		boolFlag("reuse-values", f.ReuseValues),
+		stringsFlag("set", f.Set),
+		secondsFlag("timeout", f.Timeout),
+		boolFlag("tls", f.TLS),
+		stringFlag("tls-ca-cert", f.TLSCACert),
+		stringFlag("tls-cert", f.TLSCert),
+		stringFlag("tls-key", f.TLSKey),
+		boolFlag("tls-verify", f.TLSVerify),
+		stringsFlag("values", f.Values),
+		boolFlag("verify", f.Verify),
+		stringFlag("version", f.Version),
+		boolFlag("wait", f.Wait),
+	}
+}
+
+type DeleteFlags struct {
+	DryRun    bool    `json:"dry-run"`
+	NoHooks   bool    `json:"no-hooks"`
+	Purge     bool    `json:"purge"`
+	Timeout   Seconds `json:"timeout"`
+	TLS       bool    `json:"tls"`
+	TLSCACert string  `json:"tls-ca-cert"`
+	TLSCert   string  `json:"tls-cert"`
+	TLSKey    string  `json:"tls-key"`
+	TLSVerify bool    `json:"tls-verify"`
+	// Arguments passed as is to helm, for the flags not modeled
+	ExtraArgs []string `json:"extraArgs"`
+}
+
+func (f DeleteFlags) flags() []flag {
+	return []flag{
+		boolFlag("dry-run", f.DryRun),
+		boolFlag("no-hooks", f.NoHooks),
+		boolFlag("purge", f.Purge),
+		secondsFlag("timeout", f.Timeout),
+		boolFlag("tls", f.TLS),
+		stringFlag("tls-ca-cert", f.TLSCACert),
+		stringFlag("tls-cert", f.TLSCert),
+		stringFlag("tls-key", f.TLSKey),
+		boolFlag("tls-verify", f.TLSVerify),
+	}
+}
+
+type RollbackFlags struct {
+	DryRun       bool    `json:"dry-run"`
+	Force        bool    `json:"force"`
+	NoHooks      bool    `json:"no-hooks"`
+	RecreatePods bool    `json:"recreate-pods"`
+	Timeout      Seconds `json:"timeout"`
+	TLS          bool    `json:"tls"`
+	TLSCACert    string  `json:"tls-ca-cert"`
+	TLSCert      string  `json:"tls-cert"`
+	TLSKey       string  `json:"tls-key"`
+	TLSVerify    bool    `json:"tls-verify"`
+	Wait         bool    `json:"wait"`
+	// Arguments passed as is to helm, for the flags not modeled
+	ExtraArgs []string `json:"extraArgs"`
+}
+
+func (f RollbackFlags) flags() []flag {
+	return []flag{
+		boolFlag("dry-run", f.DryRun),
+		boolFlag("force", f.Force),
+		boolFlag("no-hooks", f.NoHooks),
+		boolFlag("recreate-pods", f.RecreatePods),
+		secondsFlag("timeout", f.Timeout),
+		boolFlag("tls", f.TLS),
+		stringFlag("tls-ca-cert", f.TLSCACert),
+		stringFlag("tls-cert", f.TLSCert),
+		stringFlag("tls-key", f.TLSKey),
+		boolFlag("tls-verify", f.TLSVerify),
+		boolFlag("wait", f.Wait),
+	}
+}
+
+type ReleaseOperationsFlags struct {
+	Install  InstallFlags  `json:"install"`
+	Upgrade  UpgradeFlags  `json:"upgrade"`
+	Delete   DeleteFlags   `json:"delete"`
+	Rollback RollbackFlags `json:"rollback"`
+}
+
+// verify returns an error if one of the specified flags does not exist in
+// the helm version. The extra arguments are not verified.
+func (f ReleaseOperationsFlags) verify(version *semver.Version) error {
+	commands := []struct {
+		name  string
+		flags []flag
+	}{
+		{"install", f.Install.flags()},
+		{"upgrade", f.Upgrade.flags()},
+		{"rollback", f.Rollback.flags()},
+		{"delete", f.Delete.flags()},
+	}
+	for _, c := range commands {
+		if err := verifyFlags(c.name, c.flags, version); err != nil {
+			return err
+		}
+	}
+	return nil
+}
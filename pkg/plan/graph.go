@@ -0,0 +1,89 @@
+package plan
+
+import (
+	"fmt"
+	"sort"
+)
+
+// GraphRelease is a release of the dependency graph
+type GraphRelease struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+	// The action a run of the plan would perform, empty when unknown
+	Action string `json:"action,omitempty"`
+	// The execution level: the release is processed once the releases of
+	// the previous levels are
+	Level int `json:"level"`
+	// The releases with a higher priority are processed first in a level
+	Priority int `json:"priority"`
+}
+
+// GraphEdge is the dependency of a release on another one
+type GraphEdge struct {
+	// The release processed first
+	From string `json:"from"`
+	// The release depending on it
+	To        string `json:"to"`
+	Condition string `json:"condition"`
+}
+
+// Graph is the release dependency graph of a plan
+type Graph struct {
+	// The releases in execution order
+	Releases []GraphRelease `json:"releases"`
+	// The edges sorted by release
+	Edges []GraphEdge `json:"edges"`
+}
+
+// Graph returns the dependency graph of the releases of the targeted
+// namespaces (empty is all namespaces). The releases are annotated with the
+// actions of the statuses, if any.
+func (p *Plan) Graph(namespaces []string, statuses []ReleaseStatus) (Graph, error) {
+
+	_, specifiedMap := p.specifiedReleases(namespaceFilter(namespaces))
+
+	graph := dependencyGraph{}
+	for _, r := range specifiedMap {
+		graph = append(graph, r)
+	}
+
+	levels, err := resolveLevels(graph)
+	if err != nil {
+		return Graph{}, fmt.Errorf("Failed to resolve dependencies: %s", err)
+	}
+
+	actions := map[string]string{}
+	for _, s := range statuses {
+		actions[s.Namespace+"."+s.Name] = s.Action.String()
+	}
+
+	g := Graph{Releases: []GraphRelease{}, Edges: []GraphEdge{}}
+	for i, level := range levels {
+		for _, n := range level {
+			r := n.(Release)
+			g.Releases = append(g.Releases, GraphRelease{
+				Name:      r.Name(),
+				Namespace: r.Spec.namespace,
+				Action:    actions[r.Spec.namespace+"."+r.Name()],
+				Level:     i,
+				Priority:  r.Priority,
+			})
+			for _, dep := range r.Depends {
+				condition := dep.Condition
+				if condition == "" {
+					condition = conditionOrdered
+				}
+				g.Edges = append(g.Edges, GraphEdge{From: dep.Name, To: r.Name(), Condition: condition})
+			}
+		}
+	}
+
+	sort.Slice(g.Edges, func(i, j int) bool {
+		a, b := g.Edges[i], g.Edges[j]
+		if a.From != b.From {
+			return a.From < b.From
+		}
+		return a.To < b.To
+	})
+	return g, nil
+}
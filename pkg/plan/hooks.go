@@ -0,0 +1,153 @@
+package plan
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/rodcloutier/helm-steer/pkg/executor"
+)
+
+// Hook is a local command to execute, the first item being the executable
+type Hook []string
+
+// ReleaseHooks are the commands executed around the release operations
+type ReleaseHooks struct {
+	PreInstall  []Hook `json:"preInstall"`
+	PostInstall []Hook `json:"postInstall"`
+	PreUpgrade  []Hook `json:"preUpgrade"`
+	PostUpgrade []Hook `json:"postUpgrade"`
+	PreRollback []Hook `json:"preRollback"`
+	PostDelete  []Hook `json:"postDelete"`
+}
+
+// PlanHooks are the commands executed around the whole plan
+type PlanHooks struct {
+	PrePlan   []Hook `json:"prePlan"`
+	PostPlan  []Hook `json:"postPlan"`
+	OnFailure []Hook `json:"onFailure"`
+}
+
+// hookCommands returns the executable commands for the hooks, run with the
+// specified environment
+func hookCommands(hooks []Hook, env []string) ([]executor.Command, error) {
+	cmds := []executor.Command{}
+	for _, hook := range hooks {
+		if len(hook) == 0 {
+			return nil, errors.New("A hook must specify a command")
+		}
+		cmds = append(cmds, executor.NewExecutableCommandWithEnv(hook[0], hook[1:], env))
+	}
+	return cmds, nil
+}
+
+// HookCommands returns the executable commands for the named plan hook
+func (p *Plan) HookCommands(name string, hooks []Hook) ([]executor.Command, error) {
+	env := []string{
+		"HELM_STEER_HOOK=" + name,
+		"HELM_STEER_PLAN=" + p.path,
+	}
+	return hookCommands(hooks, env)
+}
+
+// revisionCommand records the revision of the release once the release
+// command is performed
+type revisionCommand struct {
+	executor.Command
+	revision *int32
+}
+
+func (c revisionCommand) Run(w io.Writer) (executor.Result, error) {
+	result, err := c.Command.Run(w)
+	if result.Revision != 0 {
+		*c.revision = result.Revision
+	}
+	return result, err
+}
+
+// releaseHookCommand is a release hook receiving the revision of the release
+// when it is run
+type releaseHookCommand struct {
+	hook     Hook
+	env      []string
+	revision *int32
+}
+
+func (c releaseHookCommand) String() string {
+	return strings.Join(c.hook, " ")
+}
+
+func (c releaseHookCommand) Run(w io.Writer) (executor.Result, error) {
+	env := append(c.env, fmt.Sprintf("HELM_STEER_REVISION=%d", *c.revision))
+	return executor.NewExecutableCommandWithEnv(c.hook[0], c.hook[1:], env).Run(w)
+}
+
+// hookCommands returns the executable commands for the named release hook.
+// The environment describes the release and the operation performed, the
+// revision being read when the hook is run.
+func (r Release) hookCommands(name string, hooks []Hook, action string, revision *int32) ([]executor.Command, error) {
+	env := []string{
+		"HELM_STEER_HOOK=" + name,
+		"HELM_STEER_RELEASE=" + r.Spec.name,
+		"HELM_STEER_NAMESPACE=" + r.Spec.namespace,
+		"HELM_STEER_CHART=" + r.Spec.Chart,
+		"HELM_STEER_ACTION=" + action,
+	}
+	cmds := []executor.Command{}
+	for _, hook := range hooks {
+		if len(hook) == 0 {
+			return nil, fmt.Errorf("Invalid %s hook for %s: A hook must specify a command", name, r.Name())
+		}
+		cmds = append(cmds, releaseHookCommand{hook, env, revision})
+	}
+	return cmds, nil
+}
+
+// setHooks appends the release hooks to the commands of the operation
+// according to the release action. The hooks run once the release command
+// is performed receive the revision it reported, the expected one until
+// then.
+func (r Release) setHooks(op *UndoableOperation) error {
+
+	type hook struct {
+		cmds     *[]executor.Command
+		name     string
+		hooks    []Hook
+		action   string
+		revision *int32
+	}
+
+	var hooks []hook
+	switch r.action {
+	case actionInstall:
+		revision := int32(1)
+		op.Run.Command = revisionCommand{op.Run.Command, &revision}
+		hooks = []hook{
+			{&op.Run.Pre, "preInstall", r.Hooks.PreInstall, "install", &revision},
+			{&op.Run.Post, "postInstall", r.Hooks.PostInstall, "install", &revision},
+			{&op.Undo.Post, "postDelete", r.Hooks.PostDelete, "delete", &revision},
+		}
+	case actionUpgrade:
+		revision := r.deployedRevision() + 1
+		op.Run.Command = revisionCommand{op.Run.Command, &revision}
+		hooks = []hook{
+			{&op.Run.Pre, "preUpgrade", r.Hooks.PreUpgrade, "upgrade", &revision},
+			{&op.Run.Post, "postUpgrade", r.Hooks.PostUpgrade, "upgrade", &revision},
+		}
+		if previous := r.rollbackRevision(); previous != 0 {
+			hooks = append(hooks, hook{&op.Undo.Pre, "preRollback", r.Hooks.PreRollback, "rollback", &previous})
+		} else {
+			hooks = append(hooks, hook{&op.Undo.Post, "postDelete", r.Hooks.PostDelete, "delete", &revision})
+		}
+	}
+
+	for _, h := range hooks {
+		cmds, err := r.hookCommands(h.name, h.hooks, h.action, h.revision)
+		if err != nil {
+			return err
+		}
+		*h.cmds = append(*h.cmds, cmds...)
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+package plan
+
+import (
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	"k8s.io/helm/pkg/proto/hapi/release"
+)
+
+// The version of the plans generated by Import
+const importVersion = "beta1"
+
+// ValuesFile is a values file extracted from a deployed release
+type ValuesFile struct {
+	Path    string
+	Content []byte
+}
+
+// Imported is a plan generated from the deployed releases
+type Imported struct {
+	Plan *Plan
+	// The user supplied values of the releases
+	Values []ValuesFile
+}
+
+// Import generates a plan deploying the releases of the namespaces as they
+// are currently deployed (empty is all namespaces). The chart repository is
+// not recorded by Tiller, the charts are assumed to come from repo. The user
+// supplied values of each release are extracted to valuesDir/namespace/name.yaml.
+func Import(releases []*release.Release, namespaces []string, repo, valuesDir string) Imported {
+
+	isValidNamespace := namespaceFilter(namespaces)
+	imported := Imported{
+		Plan: &Plan{
+			Version:    importVersion,
+			Namespaces: map[string]Namespace{},
+		},
+	}
+
+	for _, r := range releases {
+		if !isValidNamespace(r.Namespace) || r.Chart == nil || r.Chart.Metadata == nil {
+			continue
+		}
+		if r.GetInfo().GetStatus().GetCode() == release.Status_DELETED {
+			continue
+		}
+
+		spec := ReleaseSpec{
+			Chart: repo + "/" + r.Chart.Metadata.Name,
+		}
+		spec.Flags.Install.Version = r.Chart.Metadata.Version
+		spec.Flags.Upgrade.Version = r.Chart.Metadata.Version
+
+		if r.Config != nil {
+			raw := strings.TrimSpace(r.Config.Raw)
+			if raw != "" && raw != "{}" {
+				path := filepath.Join(valuesDir, r.Namespace, r.Name+".yaml")
+				imported.Values = append(imported.Values, ValuesFile{
+					Path:    path,
+					Content: []byte(raw + "\n"),
+				})
+				spec.Flags.Install.Values = []string{path}
+				spec.Flags.Upgrade.Values = []string{path}
+			}
+		}
+		spec.Conform(r.Namespace, r.Name)
+
+		ns, ok := imported.Plan.Namespaces[r.Namespace]
+		if !ok {
+			ns = Namespace{Releases: map[string]Release{}}
+		}
+		ns.Releases[r.Name] = Release{Spec: spec}
+		imported.Plan.Namespaces[r.Namespace] = ns
+	}
+
+	sort.Slice(imported.Values, func(i, j int) bool {
+		return imported.Values[i].Path < imported.Values[j].Path
+	})
+	return imported
+}
+
+// YAML returns the plan file of the imported plan. Only the chart, version
+// and values of the releases are written, the other flags keep their
+// defaults.
+func (i Imported) YAML() ([]byte, error) {
+
+	type flags struct {
+		Version string   `json:"version"`
+		Values  []string `json:"values,omitempty"`
+	}
+	type spec struct {
+		Chart string           `json:"chart"`
+		Flags map[string]flags `json:"flags"`
+	}
+	type release struct {
+		Spec spec `json:"spec"`
+	}
+	type namespace struct {
+		Releases map[string]release `json:"releases"`
+	}
+
+	namespaces := map[string]namespace{}
+	for name, ns := range i.Plan.Namespaces {
+		releases := map[string]release{}
+		for releaseName, r := range ns.Releases {
+			releases[releaseName] = release{
+				Spec: spec{
+					Chart: r.Spec.Chart,
+					Flags: map[string]flags{
+						"install": {r.Spec.Flags.Install.Version, r.Spec.Flags.Install.Values},
+						"upgrade": {r.Spec.Flags.Upgrade.Version, r.Spec.Flags.Upgrade.Values},
+					},
+				},
+			}
+		}
+		namespaces[name] = namespace{Releases: releases}
+	}
+
+	return yaml.Marshal(struct {
+		Version    string               `json:"version"`
+		Namespaces map[string]namespace `json:"namespaces"`
+	}{i.Plan.Version, namespaces})
+}
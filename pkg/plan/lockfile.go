@@ -0,0 +1,144 @@
+package plan
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ghodss/yaml"
+
+	"github.com/rodcloutier/helm-steer/pkg/helm"
+)
+
+// LockFile pins the chart versions resolved for the plan releases
+type LockFile struct {
+	// The digest of the plan the lock was generated from
+	Digest    string          `json:"digest"`
+	Generated time.Time       `json:"generated"`
+	Releases  []LockedRelease `json:"releases"`
+}
+
+// LockedRelease is the chart version resolved for a release
+type LockedRelease struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	Chart     string `json:"chart"`
+	Version   string `json:"version"`
+	Digest    string `json:"digest,omitempty"`
+}
+
+// LockPath returns the path of the lock file of the plan, plan.yaml being
+// locked by plan.lock
+func LockPath(planPath string) string {
+	return strings.TrimSuffix(planPath, filepath.Ext(planPath)) + ".lock"
+}
+
+func digest(content []byte) string {
+	return fmt.Sprintf("sha256:%x", sha256.Sum256(content))
+}
+
+// LoadLockFile loads the lock file at path. It returns nil if the file does
+// not exist.
+func LoadLockFile(path string) (*LockFile, error) {
+	content, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var lf LockFile
+	if err := yaml.Unmarshal(content, &lf); err != nil {
+		return nil, err
+	}
+	return &lf, nil
+}
+
+// Save writes the lock file to path
+func (lf *LockFile) Save(path string) error {
+	content, err := yaml.Marshal(lf)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, content, 0644)
+}
+
+// Lock resolves the chart of every release of the plan into an exact version
+// and digest from the repository indexes
+func (p *Plan) Lock() (*LockFile, error) {
+
+	lf := &LockFile{
+		Digest:    p.digest,
+		Generated: time.Now().UTC(),
+		Releases:  []LockedRelease{},
+	}
+
+	for _, namespaceName := range p.NamespaceNames(nil) {
+		ns := p.Namespaces[namespaceName]
+		names := []string{}
+		for name := range ns.Releases {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			spec := ns.Releases[name].Spec
+			if err := spec.loadLocalChart(); err != nil {
+				return nil, err
+			}
+			locked := LockedRelease{
+				Namespace: namespaceName,
+				Name:      name,
+				Chart:     spec.Chart,
+				Version:   spec.Version(),
+			}
+			// Only repository charts can be resolved
+			if _, _, err := helm.SplitChart(spec.Chart); err == nil && !spec.isLocal() {
+				constraint := spec.Version()
+				if constraint == "" {
+					constraint = "*"
+				}
+				cv, err := resolveVersion(spec.Chart, constraint)
+				if err != nil {
+					return nil, fmt.Errorf("Failed to resolve the version of %s: %s", name, err)
+				}
+				locked.Version = cv.Version
+				locked.Digest = cv.Digest
+			}
+			lf.Releases = append(lf.Releases, locked)
+		}
+	}
+	return lf, nil
+}
+
+// UseLock pins the releases versions to the ones of the lock file. It fails
+// if the plan changed since the lock file was generated.
+func (p *Plan) UseLock(lf *LockFile) error {
+
+	if lf.Digest != p.digest {
+		return fmt.Errorf("The lock file is out of date, run `helm steer lock %s` to update it", p.path)
+	}
+
+	for _, locked := range lf.Releases {
+		ns, ok := p.Namespaces[locked.Namespace]
+		if !ok {
+			continue
+		}
+		release, ok := ns.Releases[locked.Name]
+		if !ok || locked.Version == "" {
+			continue
+		}
+		release.Spec.pinVersion(locked.Version)
+		// The locked version is an exact pin, the deployed versions
+		// satisfying the range are upgraded to it
+		release.Spec.constraint = ""
+		release.Spec.digest = locked.Digest
+		ns.Releases[locked.Name] = release
+	}
+	return nil
+}
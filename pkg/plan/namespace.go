@@ -0,0 +1,320 @@
+package plan
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rodcloutier/helm-steer/pkg/executor"
+	"github.com/rodcloutier/helm-steer/pkg/kube"
+)
+
+// The annotation recording the plan that created a namespace
+const createdByAnnotation = "helm-steer/created-by"
+
+// The name of the ResourceQuota and LimitRange applied by steer
+const resourceName = "helm-steer"
+
+// The functions reading the cluster objects and building the kubectl
+// commands
+var (
+	getObject    = kube.Get
+	kubeCommand  = kube.Command
+	applyCommand = kube.NewApplyCommand
+)
+
+type Namespace struct {
+	Releases map[string]Release
+	// Create the namespace if it does not exist
+	Create      bool              `json:"create"`
+	Labels      map[string]string `json:"labels"`
+	Annotations map[string]string `json:"annotations"`
+	// The specs of the ResourceQuota and LimitRange applied in the namespace
+	ResourceQuota map[string]interface{} `json:"resourceQuota"`
+	LimitRange    map[string]interface{} `json:"limitRange"`
+}
+
+// managed reports whether steer has something to apply to the namespace
+func (ns Namespace) managed() bool {
+	return ns.Create || len(ns.Labels) != 0 || len(ns.Annotations) != 0 ||
+		len(ns.ResourceQuota) != 0 || len(ns.LimitRange) != 0
+}
+
+// keyValues returns the sorted key=value pairs of the map
+func keyValues(m map[string]string) []string {
+	kv := []string{}
+	for k, v := range m {
+		kv = append(kv, k+"="+v)
+	}
+	sort.Strings(kv)
+	return kv
+}
+
+// resources returns the kinds of the resources of the namespace with their
+// specs, if specified
+func (ns Namespace) resources() []namespaceResource {
+	resources := []namespaceResource{}
+	if len(ns.ResourceQuota) != 0 {
+		resources = append(resources, namespaceResource{"ResourceQuota", ns.ResourceQuota})
+	}
+	if len(ns.LimitRange) != 0 {
+		resources = append(resources, namespaceResource{"LimitRange", ns.LimitRange})
+	}
+	return resources
+}
+
+type namespaceResource struct {
+	kind string
+	spec map[string]interface{}
+}
+
+// manifest returns the manifest of a resource of the namespace named after
+// steer with the specified spec
+func manifest(kind, namespace string, spec map[string]interface{}) ([]byte, error) {
+	return json.Marshal(map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       kind,
+		"metadata": map[string]string{
+			"name":      resourceName,
+			"namespace": namespace,
+		},
+		"spec": spec,
+	})
+}
+
+// stringMap returns the string values of the field of the object
+func stringMap(object map[string]interface{}, field string) map[string]string {
+	m := map[string]string{}
+	values, _ := object[field].(map[string]interface{})
+	for k, v := range values {
+		if s, ok := v.(string); ok {
+			m[k] = s
+		}
+	}
+	return m
+}
+
+// namespaceState is the state of a namespace before its preparation, shared
+// by the commands preparing it and the one undoing the preparation
+type namespaceState struct {
+	// The namespace was prepared
+	prepared bool
+	// The namespace was created by the preparation
+	created bool
+	// The previous state was recorded
+	recorded bool
+	// The labels and annotations before the preparation
+	labels      map[string]string
+	annotations map[string]string
+	// The specs of the resources before the preparation by kind, nil when
+	// they did not exist
+	resources map[string]map[string]interface{}
+}
+
+// prepareNamespaceCommand creates the namespace if requested and applies its
+// labels, annotations and resources. The existence of the namespace is only
+// checked when the command is run.
+type prepareNamespaceCommand struct {
+	name  string
+	owner string
+	ns    Namespace
+	// The command is performed after the first release of the namespace,
+	// helm having created the namespace if it was missing
+	afterRelease bool
+	state        *namespaceState
+}
+
+func (c prepareNamespaceCommand) String() string {
+	steps := []string{}
+	if c.ns.Create {
+		steps = append(steps, "create if missing")
+	}
+	if len(c.ns.Labels) != 0 {
+		steps = append(steps, "label "+strings.Join(keyValues(c.ns.Labels), " "))
+	}
+	if len(c.ns.Annotations) != 0 {
+		steps = append(steps, "annotate "+strings.Join(keyValues(c.ns.Annotations), " "))
+	}
+	for _, r := range c.ns.resources() {
+		steps = append(steps, "apply "+r.kind)
+	}
+	return fmt.Sprintf("prepare namespace %s: %s", c.name, strings.Join(steps, ", "))
+}
+
+func (c prepareNamespaceCommand) Run(w io.Writer) (executor.Result, error) {
+	start := time.Now()
+	result := func(err error) (executor.Result, error) {
+		return executor.Result{Duration: time.Since(start)}, err
+	}
+	if c.state.prepared {
+		return result(nil)
+	}
+
+	current, err := getObject("namespace", "", c.name)
+	if err != nil {
+		return result(err)
+	}
+
+	annotations := map[string]string{}
+	for k, v := range c.ns.Annotations {
+		annotations[k] = v
+	}
+	if current == nil {
+		if !c.ns.Create {
+			if c.afterRelease {
+				return result(fmt.Errorf("Namespace %s does not exist", c.name))
+			}
+			fmt.Fprintf(w, "Namespace %s does not exist yet, it is prepared after its first release\n", c.name)
+			return result(nil)
+		}
+		if _, err := kubeCommand("create", "namespace", c.name).Run(w); err != nil {
+			return result(err)
+		}
+		c.state.created = true
+		annotations[createdByAnnotation] = c.owner
+	} else if err := c.record(current); err != nil {
+		return result(err)
+	}
+
+	cmds := []executor.Command{}
+	if labels := keyValues(c.ns.Labels); len(labels) != 0 {
+		args := append([]string{"label", "namespace", c.name, "--overwrite"}, labels...)
+		cmds = append(cmds, kubeCommand(args...))
+	}
+	if annotations := keyValues(annotations); len(annotations) != 0 {
+		args := append([]string{"annotate", "namespace", c.name, "--overwrite"}, annotations...)
+		cmds = append(cmds, kubeCommand(args...))
+	}
+	for _, r := range c.ns.resources() {
+		m, err := manifest(r.kind, c.name, r.spec)
+		if err != nil {
+			return result(err)
+		}
+		cmds = append(cmds, applyCommand(fmt.Sprintf("%s in namespace %s", r.kind, c.name), m))
+	}
+	for _, cmd := range cmds {
+		if _, err := cmd.Run(w); err != nil {
+			return result(err)
+		}
+	}
+	c.state.prepared = true
+	return result(nil)
+}
+
+// record records the labels, annotations and resources of the existing
+// namespace for the undo
+func (c prepareNamespaceCommand) record(namespace map[string]interface{}) error {
+	metadata, _ := namespace["metadata"].(map[string]interface{})
+	c.state.labels = stringMap(metadata, "labels")
+	c.state.annotations = stringMap(metadata, "annotations")
+	c.state.resources = map[string]map[string]interface{}{}
+	for _, r := range c.ns.resources() {
+		object, err := getObject(r.kind, c.name, resourceName)
+		if err != nil {
+			return err
+		}
+		if object != nil {
+			spec, _ := object["spec"].(map[string]interface{})
+			c.state.resources[r.kind] = spec
+		}
+	}
+	c.state.recorded = true
+	return nil
+}
+
+// restoreNamespaceCommand undoes the preparation of the namespace: it deletes
+// the namespace if it was created, or restores its previous labels,
+// annotations and resources
+type restoreNamespaceCommand struct {
+	name  string
+	ns    Namespace
+	state *namespaceState
+}
+
+func (c restoreNamespaceCommand) String() string {
+	return fmt.Sprintf("restore namespace %s", c.name)
+}
+
+// restoreArgs returns the kubectl label or annotate arguments restoring the
+// previous values of the keys, removing the keys that were absent
+func restoreArgs(keys map[string]string, previous map[string]string) []string {
+	args := []string{}
+	for k := range keys {
+		if v, ok := previous[k]; ok {
+			args = append(args, k+"="+v)
+		} else {
+			args = append(args, k+"-")
+		}
+	}
+	sort.Strings(args)
+	return args
+}
+
+func (c restoreNamespaceCommand) Run(w io.Writer) (executor.Result, error) {
+	start := time.Now()
+	cmds := []executor.Command{}
+	switch {
+	case c.state.created:
+		cmds = append(cmds, kubeCommand("delete", "namespace", c.name))
+	case c.state.recorded:
+		if args := restoreArgs(c.ns.Labels, c.state.labels); len(args) != 0 {
+			cmds = append(cmds, kubeCommand(append([]string{"label", "namespace", c.name, "--overwrite"}, args...)...))
+		}
+		if args := restoreArgs(c.ns.Annotations, c.state.annotations); len(args) != 0 {
+			cmds = append(cmds, kubeCommand(append([]string{"annotate", "namespace", c.name, "--overwrite"}, args...)...))
+		}
+		for _, r := range c.ns.resources() {
+			previous := c.state.resources[r.kind]
+			if previous == nil {
+				cmds = append(cmds, kubeCommand("delete", r.kind, resourceName, "--namespace", c.name, "--ignore-not-found"))
+				continue
+			}
+			m, err := manifest(r.kind, c.name, previous)
+			if err != nil {
+				return executor.Result{}, err
+			}
+			cmds = append(cmds, applyCommand(fmt.Sprintf("previous %s in namespace %s", r.kind, c.name), m))
+		}
+	}
+	for _, cmd := range cmds {
+		if _, err := cmd.Run(w); err != nil {
+			return executor.Result{Duration: time.Since(start)}, err
+		}
+	}
+	return executor.Result{Duration: time.Since(start)}, nil
+}
+
+// namespaceOperation returns the operation preparing the namespace before
+// its first release. A namespace that is not created by steer may only be
+// created by helm on the install of its first release: the returned command
+// then prepares it once the release is performed. The undo deletes the
+// namespace if it was created by the operation, or restores the previous
+// labels, annotations and resources.
+func (p *Plan) namespaceOperation(name string) (*UndoableOperation, executor.Command) {
+
+	ns := p.Namespaces[name]
+	if !ns.managed() {
+		return nil, nil
+	}
+
+	state := &namespaceState{}
+	prepare := prepareNamespaceCommand{name: name, owner: p.ID(), ns: ns, state: state}
+	op := &UndoableOperation{
+		Run: Operation{
+			Description: fmt.Sprintf("Preparing namespace %s", name),
+			Command:     prepare,
+		},
+		Undo: Operation{
+			Description: fmt.Sprintf("Restoring namespace %s", name),
+			Command:     restoreNamespaceCommand{name: name, ns: ns, state: state},
+		},
+	}
+	if ns.Create {
+		return op, nil
+	}
+	prepare.afterRelease = true
+	return op, prepare
+}
@@ -1,16 +1,19 @@
 package plan
 
 import (
-	"errors"
 	"fmt"
 	"io/ioutil"
+	"path/filepath"
+	"sort"
 
 	"github.com/Masterminds/semver"
 	"github.com/deckarep/golang-set"
 	"github.com/ghodss/yaml"
 	"k8s.io/helm/pkg/proto/hapi/release"
 
+	"github.com/rodcloutier/helm-steer/pkg/executor"
 	"github.com/rodcloutier/helm-steer/pkg/helm"
+	"github.com/rodcloutier/helm-steer/pkg/ownership"
 )
 
 type Action int
@@ -19,109 +22,172 @@ const (
 	actionInstall Action = iota
 	actionUpgrade
 	actionDelete
+	// actionNone is for the releases that are up to date. They are kept
+	// in the dependency graph but do not produce operations.
+	actionNone
 )
 
+// The function resolving the chart version ranges
+var resolveVersion = helm.ResolveVersion
+
+// The function listing the deployed releases
+var listReleases = helm.List
+
+// The store recording the plan owning each release
+var ownershipStore = ownership.NewConfigMapStore()
+
+// ProcessOptions are the options used when processing a plan
+type ProcessOptions struct {
+	// The namespaces targeted (empty is all namespaces)
+	Namespaces []string
+	// Upgrade the releases to the latest version satisfying their range even
+	// if the deployed version already satisfies it
+	Latest bool
+	// Allow upgrading a release to a chart different from the deployed one
+	AllowChartChange bool
+	// Take over the releases owned by another plan or without recorded owner
+	Adopt bool
+	// Selects the releases to process, the others are left untouched
+	Selector Selector
+	// The executor performing the release operations, ExecutorCLI or
+	// ExecutorLibrary
+	Executor string
+	// The repositories whose index is not available yet, the version ranges
+	// of their charts are left unresolved. A dry run does not add the plan
+	// repositories.
+	UnresolvedRepositories []string
+}
+
 type Release struct {
-	Spec    ReleaseSpec `json:"spec"`
-	Depends []string    `json:"depends"`
+	Spec    ReleaseSpec  `json:"spec"`
+	Depends []Dependency `json:"depends"`
+	Checks  []Check      `json:"checks"`
+	Hooks   ReleaseHooks `json:"hooks"`
+	// Labels used to select the releases to process
+	Labels map[string]string `json:"labels"`
+	// The releases with a higher priority are processed first among the
+	// releases whose dependencies are met
+	Priority int `json:"priority"`
 
 	action  Action
 	release *release.Release
-}
-
-type Namespace struct {
-	Releases map[string]Release
+	// The plan owning the deployed release
+	owner string
 }
 
 type Plan struct {
+	// The name identifying the plan as the owner of its releases, defaults
+	// to the absolute path of the plan file
+	Name       string               `json:"name"`
 	Namespaces map[string]Namespace `json:"namespaces"`
 	Version    string               `json:"version"`
+	Hooks      PlanHooks            `json:"hooks"`
+	// The chart repositories used by the releases
+	Repositories []Repository `json:"repositories"`
+	// The cluster and Tiller the plan is deployed to
+	Cluster Cluster `json:"cluster"`
+
+	// The path of the file the plan was loaded from
+	path string
+	// The digest of the plan content
+	digest string
 }
 
 type Operation struct {
 	Description string
-	Command     []string
+	// The command performing the operation, nil when there is nothing to do
+	Command executor.Command
+	// Pre and Post are local commands executed around the Command
+	Pre  []executor.Command
+	Post []executor.Command
 }
 
 type UndoableOperation struct {
+	// The namespace and release the operation applies to, the release being
+	// empty for namespace operations
+	Namespace string
+	Release   string
+	// The releases on which the release depends
+	Depends []string
+
 	Run  Operation
 	Undo Operation
+	// Wait are performed before Run, waiting for the dependencies to be ready
+	Wait []executor.Command
+	// Checks are performed once Run succeeded, a failure triggers the undo
+	Checks []executor.Command
 }
 
 // Process will process the plan to extract a dependencies sorted list
 // of operations to perform
-func (p *Plan) Process(namespaces []string) ([]UndoableOperation, error) {
+func (p *Plan) Process(opts ProcessOptions) ([]UndoableOperation, error) {
 
-	// TODO (rod) do this per namespace ?
-	isValidNamespace := func(string) bool { return true }
-	if len(namespaces) != 0 {
-		namespacesMap := map[string]bool{}
-		for _, ns := range namespaces {
-			namespacesMap[ns] = true
-		}
-		isValidNamespace = func(ns string) bool {
-			_, ok := namespacesMap[ns]
-			return ok
-		}
-	}
+	isValidNamespace := namespaceFilter(opts.Namespaces)
 
 	// List the currently installed chart deployments
-	rawCurrentReleases, err := helm.List()
+	rawCurrentReleases, err := listReleases()
 	if err != nil {
-		fmt.Println("Error: Failed to fetch helm list: %s", err)
+		fmt.Printf("Error: Failed to fetch helm list: %s\n", err)
 		return nil, err
 	}
 
-	specifiedReleases := mapset.NewSet()
-	specifiedReleasesMap := make(map[string]Release)
-	for namespaceName, ns := range p.Namespaces {
-		if !isValidNamespace(namespaceName) {
-			continue
-		}
-		for releaseName, _ := range ns.Releases {
-			key := namespaceName + "." + releaseName
-			specifiedReleases.Add(key)
-			specifiedReleasesMap[key] = ns.Releases[releaseName]
-		}
-	}
+	specifiedReleases, specifiedReleasesMap := p.specifiedReleases(isValidNamespace)
 
 	if specifiedReleases.Cardinality() == 0 {
 		fmt.Println("Nothing to do, no release found")
 		return nil, nil
 	}
 
-	currentReleases := mapset.NewSet()
-	currentReleasesMap := make(map[string]*release.Release)
-	for _, r := range rawCurrentReleases {
-		if !isValidNamespace(r.Namespace) {
-			continue
-		}
-		_, ok := p.Namespaces[r.Namespace]
-		if !ok {
-			continue
-		}
-		key := r.Namespace + "." + r.Name
-		currentReleases.Add(key)
-		currentReleasesMap[key] = r
+	selected, err := opts.Selector.selectReleases(specifiedReleasesMap)
+	if err != nil {
+		return nil, err
+	}
+	if selected.Cardinality() == 0 {
+		fmt.Println("Nothing to do, no release selected")
+		return nil, nil
+	}
+
+	// The releases not selected are left untouched, their charts are not
+	// resolved
+	specifiedReleasesMap, err = resolveCharts(selected, specifiedReleasesMap, opts.UnresolvedRepositories)
+	if err != nil {
+		return nil, err
 	}
 
+	currentReleases, currentReleasesMap := p.currentReleases(isValidNamespace, rawCurrentReleases)
+
 	// TODO (rod): delete is a special case where we do not have a Release defined
 	// we need to see how to handle this
 	// delete := currentReleases.Difference(specifiedReleases)
 
-	/// TODO (rod): Validate that the chart names match the same release name
-	// in the same namespace
-
 	install := specifiedReleases.Difference(currentReleases)
 	known := specifiedReleases.Intersect(currentReleases)
+	specifiedReleasesMap = replaceDeleted(install.Intersect(selected), rawCurrentReleases, specifiedReleasesMap)
+
+	// Validate that the chart names match the same release name in the same
+	// namespace
+	// Only the selected releases will be touched
+	if err := checkChartNames(known.Intersect(selected), currentReleasesMap, specifiedReleasesMap, opts.AllowChartChange); err != nil {
+		fmt.Printf("Error: %s\n", err)
+		return nil, err
+	}
+
+	specifiedReleasesMap, err = p.checkOwners(known.Intersect(selected), specifiedReleasesMap, opts.Adopt)
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		return nil, err
+	}
 
 	specifiedReleasesMap = bindReleases(known, specifiedReleasesMap, currentReleasesMap)
 
-	upgrade := known
-	// upgrade, err := extractUpgrades(known, releasesMap, specifiedReleasesMap)
+	upgrade, err := extractUpgrades(known.Intersect(selected), currentReleasesMap, specifiedReleasesMap, opts.Latest)
 	if err != nil {
 		return nil, err
 	}
+	unchanged := known.Intersect(selected).Difference(upgrade)
+	for _, r := range sortedNames(unchanged) {
+		fmt.Printf("%s is up to date\n", specifiedReleasesMap[r])
+	}
 
 	fmt.Println("Resolving dependencies")
 
@@ -135,8 +201,17 @@ func (p *Plan) Process(namespaces []string) ([]UndoableOperation, error) {
 	}
 	setAction(install, actionInstall)
 	setAction(upgrade, actionUpgrade)
+	setAction(unchanged, actionNone)
+	// The releases not selected are kept in the graph to order the selected
+	// ones but are left untouched
+	setAction(specifiedReleases.Difference(selected), actionNone)
 
-	releases := install.Union(upgrade).ToSlice()
+	if err := checkDependenciesDeployed(selected, install, specifiedReleasesMap); err != nil {
+		fmt.Printf("Error: %s\n", err)
+		return nil, err
+	}
+
+	releases := install.Union(known).ToSlice()
 	graph := make(dependencyGraph, len(releases))
 	for i, s := range releases {
 		graph[i] = specifiedReleasesMap[s.(string)]
@@ -149,7 +224,194 @@ func (p *Plan) Process(namespaces []string) ([]UndoableOperation, error) {
 	}
 
 	fmt.Println("Creating list of operations to perform")
-	return createOperations(graph)
+	ops, err := p.createOperations(graph, opts.Executor)
+	if err != nil {
+		return nil, err
+	}
+	return append(ops, p.ownershipOperations(unchanged, specifiedReleasesMap)...), nil
+}
+
+// ownershipOperations returns the operations recording the plan as the owner
+// of the unchanged releases it does not own yet
+func (p *Plan) ownershipOperations(unchanged mapset.Set, specifiedReleasesMap map[string]Release) []UndoableOperation {
+	owner := p.ID()
+	ops := []UndoableOperation{}
+	for _, name := range sortedNames(unchanged) {
+		s := specifiedReleasesMap[name]
+		if s.owner == owner {
+			continue
+		}
+		ops = append(ops, UndoableOperation{
+			Namespace: s.Spec.namespace,
+			Release:   s.Name(),
+			Run: Operation{
+				Description: fmt.Sprintf("Recording the owner of %s", s),
+				Command:     ownership.NewSetOwnerCommand(ownershipStore, s.Spec.namespace, s.Name(), owner),
+			},
+			Undo: Operation{
+				Description: fmt.Sprintf("Restoring the owner of %s", s),
+				Command:     ownership.NewSetOwnerCommand(ownershipStore, s.Spec.namespace, s.Name(), s.owner),
+			},
+		})
+	}
+	return ops
+}
+
+// specifiedReleases returns the releases of the targeted namespaces, keyed
+// by namespace.name
+func (p *Plan) specifiedReleases(isValidNamespace func(string) bool) (mapset.Set, map[string]Release) {
+	specified := mapset.NewSet()
+	specifiedMap := make(map[string]Release)
+	for namespaceName, ns := range p.Namespaces {
+		if !isValidNamespace(namespaceName) {
+			continue
+		}
+		for releaseName, _ := range ns.Releases {
+			key := namespaceName + "." + releaseName
+			specified.Add(key)
+			specifiedMap[key] = ns.Releases[releaseName]
+		}
+	}
+	return specified, specifiedMap
+}
+
+// currentReleases returns the deployed releases of the targeted namespaces of
+// the plan, keyed by namespace.name
+func (p *Plan) currentReleases(isValidNamespace func(string) bool, deployed []*release.Release) (mapset.Set, map[string]*release.Release) {
+	current := mapset.NewSet()
+	currentMap := make(map[string]*release.Release)
+	for _, r := range deployed {
+		// A release deleted without purge is only kept in the history
+		if !isValidNamespace(r.Namespace) || r.GetInfo().GetStatus().GetCode() == release.Status_DELETED {
+			continue
+		}
+		_, ok := p.Namespaces[r.Namespace]
+		if !ok {
+			continue
+		}
+		key := r.Namespace + "." + r.Name
+		current.Add(key)
+		currentMap[key] = r
+	}
+	return current, currentMap
+}
+
+// replaceDeleted installs the releases deleted without purge with the
+// replace flag, helm refusing to reuse their name otherwise
+func replaceDeleted(install mapset.Set, deployed []*release.Release, specifiedReleasesMap map[string]Release) map[string]Release {
+	for _, r := range deployed {
+		key := r.Namespace + "." + r.Name
+		if r.GetInfo().GetStatus().GetCode() != release.Status_DELETED || !install.Contains(key) {
+			continue
+		}
+		s := specifiedReleasesMap[key]
+		s.Spec.Flags.Install.Replace = true
+		specifiedReleasesMap[key] = s
+		fmt.Printf("%s was deleted, it is installed again replacing the deleted release\n", s.Name())
+	}
+	return specifiedReleasesMap
+}
+
+// namespaceFilter returns a function that reports whether a namespace is
+// targeted. An empty list targets all namespaces.
+func namespaceFilter(namespaces []string) func(string) bool {
+	if len(namespaces) == 0 {
+		return func(string) bool { return true }
+	}
+	namespacesMap := map[string]bool{}
+	for _, ns := range namespaces {
+		namespacesMap[ns] = true
+	}
+	return func(ns string) bool {
+		_, ok := namespacesMap[ns]
+		return ok
+	}
+}
+
+// NamespaceNames returns the sorted names of the plan namespaces that are
+// targeted by the namespaces filter
+func (p *Plan) NamespaceNames(namespaces []string) []string {
+	isValidNamespace := namespaceFilter(namespaces)
+	names := []string{}
+	for name := range p.Namespaces {
+		if isValidNamespace(name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ID returns the identifier of the plan used to record the ownership of its
+// releases. Without name, the absolute path of the plan file tells apart the
+// plans sharing a file name.
+func (p *Plan) ID() string {
+	if p.Name != "" || p.path == "" {
+		return p.Name
+	}
+	path, err := filepath.Abs(p.path)
+	if err != nil {
+		return p.path
+	}
+	return path
+}
+
+// checkOwners binds the owner of the known releases and ensures they are not
+// owned by another plan, unless adopted. Releases without owner, like the
+// ones installed manually, must be adopted as well.
+func (p *Plan) checkOwners(known mapset.Set, specifiedReleasesMap map[string]Release, adopt bool) (map[string]Release, error) {
+
+	owners := map[string]map[string]string{}
+	for r := range known.Iter() {
+		name := r.(string)
+		release := specifiedReleasesMap[name]
+		namespace := release.Spec.namespace
+
+		if _, ok := owners[namespace]; !ok {
+			o, err := ownershipStore.Owners(namespace)
+			if err != nil {
+				return nil, err
+			}
+			owners[namespace] = o
+		}
+
+		owner := owners[namespace][release.Name()]
+		switch {
+		case owner == "" && !adopt:
+			return nil, fmt.Errorf("Release %s has no recorded owner (use --adopt to take it over)", release.Name())
+		case owner == "":
+			fmt.Printf("Warning: Adopting release %s without recorded owner\n", release.Name())
+		case owner != p.ID() && !adopt:
+			return nil, fmt.Errorf("Release %s is owned by plan %s (use --adopt to take it over)", release.Name(), owner)
+		case owner != p.ID():
+			fmt.Printf("Warning: Adopting release %s owned by plan %s\n", release.Name(), owner)
+		}
+		release.owner = owner
+		specifiedReleasesMap[name] = release
+	}
+	return specifiedReleasesMap, nil
+}
+
+// VerifyFlags verifies that the flags of the releases of the targeted
+// namespaces exist in the helm version
+func (p *Plan) VerifyFlags(namespaces []string, helmVersion string) error {
+	version, err := semver.NewVersion(helmVersion)
+	if err != nil {
+		return fmt.Errorf("Invalid helm version `%s`: %s", helmVersion, err)
+	}
+	for _, name := range p.NamespaceNames(namespaces) {
+		for releaseName, release := range p.Namespaces[name].Releases {
+			if err := release.Spec.Flags.verify(version); err != nil {
+				return fmt.Errorf("Invalid flags for %s.%s: %s", name, releaseName, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Path returns the path of the file the plan was loaded from
+func (p *Plan) Path() string {
+	return p.path
 }
 
 func bindReleases(releases mapset.Set, specifiedReleasesMap map[string]Release, currentReleasesMap map[string]*release.Release) map[string]Release {
@@ -165,95 +427,250 @@ func bindReleases(releases mapset.Set, specifiedReleasesMap map[string]Release,
 	return boundReleaseMap
 }
 
-// createOperations creates a list of operations based on the specified dependency graph
-func createOperations(graph dependencyGraph) ([]UndoableOperation, error) {
+// createOperations creates a list of operations based on the specified
+// dependency graph, performed with the named executor. The namespaces are
+// prepared before their first release.
+func (p *Plan) createOperations(graph dependencyGraph, executorName string) ([]UndoableOperation, error) {
 
-	operations := map[Action]func(Release) UndoableOperation{
-		actionInstall: func(s Release) UndoableOperation {
+	owner := p.ID()
+	exec, err := newReleaseExecutor(executorName)
+	if err != nil {
+		return nil, err
+	}
+
+	operations := map[Action]func(Release) (UndoableOperation, error){
+		actionInstall: func(s Release) (UndoableOperation, error) {
+			install, err := exec.install(s.Spec)
+			if err != nil {
+				return UndoableOperation{}, err
+			}
+			del, err := exec.delete(s.Spec)
+			if err != nil {
+				return UndoableOperation{}, err
+			}
 			return UndoableOperation{
 				Run: Operation{
 					Description: fmt.Sprintf("Installing %s", s),
-					Command:     s.Spec.installCmd(),
+					Command:     install,
 				},
 				Undo: Operation{
 					Description: fmt.Sprintf("Deleting %s", s),
-					Command:     s.Spec.deleteCmd(),
+					Command:     del,
 				},
-			}
+			}, nil
 		},
-		actionUpgrade: func(s Release) UndoableOperation {
+		actionUpgrade: func(s Release) (UndoableOperation, error) {
+			upgrade, err := exec.upgrade(s.Spec)
+			if err != nil {
+				return UndoableOperation{}, err
+			}
+			var undo executor.Command
+			if revision := s.rollbackRevision(); revision != 0 {
+				undo, err = exec.rollback(s.Spec, revision)
+			} else {
+				undo, err = exec.delete(s.Spec)
+			}
+			if err != nil {
+				return UndoableOperation{}, err
+			}
 			return UndoableOperation{
 				Run: Operation{
 					Description: fmt.Sprintf("Upgrading %s", s),
-					Command:     s.Spec.upgradeCmd(),
+					Command:     upgrade,
 				},
 				Undo: Operation{
 					Description: fmt.Sprintf("Rollback on %s", s),
-					// TODO catch the case were the Version is 1 or release is nil
-					Command: func() []string {
-						if s.release == nil || s.release.Version <= 1 {
-							return s.Spec.deleteCmd()
-						}
-						return s.Spec.rollbackCmd(s.release.Version - 1)
-					}(),
+					Command:     undo,
 				},
-			}
+			}, nil
 		},
 	}
 
 	ops := []UndoableOperation{}
+	prepared := map[string]bool{}
 	for _, r := range graph {
 		s := r.(Release)
-		ops = append(ops, operations[s.action](s))
+		if s.action == actionNone {
+			continue
+		}
+		var prepareAfter executor.Command
+		if namespace := s.Spec.namespace; !prepared[namespace] {
+			prepared[namespace] = true
+			op, after := p.namespaceOperation(namespace)
+			if op != nil {
+				op.Namespace = namespace
+				ops = append(ops, *op)
+			}
+			prepareAfter = after
+		}
+		op, err := operations[s.action](s)
+		if err != nil {
+			return nil, err
+		}
+		if prepareAfter != nil {
+			op.Run.Post = append(op.Run.Post, prepareAfter)
+		}
+		op.Namespace = s.Spec.namespace
+		op.Release = s.Name()
+		op.Depends = s.Deps()
+		if err := s.setHooks(&op); err != nil {
+			return nil, err
+		}
+		wait, err := s.waitCommands(graph)
+		if err != nil {
+			return nil, err
+		}
+		op.Wait = wait
+		if s.owner != owner {
+			op.Run.Post = append(op.Run.Post, ownership.NewSetOwnerCommand(ownershipStore, s.Spec.namespace, s.Name(), owner))
+			op.Undo.Post = append(op.Undo.Post, ownership.NewSetOwnerCommand(ownershipStore, s.Spec.namespace, s.Name(), s.owner))
+		}
+		for _, check := range s.Checks {
+			cmd, err := check.command(s.Name())
+			if err != nil {
+				return nil, fmt.Errorf("Invalid check for %s: %s", s.Name(), err)
+			}
+			op.Checks = append(op.Checks, cmd)
+		}
+		ops = append(ops, op)
 	}
 
 	return ops, nil
 }
 
-func extractUpgrades(known mapset.Set, releasesMap map[string]*release.Release, specifiedReleasesMap map[string]Release) (mapset.Set, error) {
+// extractUpgrades returns the known releases that must be upgraded. A
+// release whose version is a range already satisfied by the deployed version
+// is up to date, unless latest is set and a newer version was resolved.
+func extractUpgrades(known mapset.Set, releasesMap map[string]*release.Release, specifiedReleasesMap map[string]Release, latest bool) (mapset.Set, error) {
 
 	upgrade := mapset.NewSet()
 
 	for r := range known.Iter() {
 
 		release := r.(string)
-		specifiedVersion := specifiedReleasesMap[release].Version()
+		spec := specifiedReleasesMap[release].Spec
+		deployed := releasesMap[release]
+
+		// A local chart is upgraded when its content changed, even if its
+		// version did not
+		if spec.localChart != nil {
+			if helm.ChartDigest(deployed.Chart) != spec.localDigest {
+				upgrade.Add(release)
+			}
+			continue
+		}
 
-		// No version is specified, we must asssume that we will potentially
-		// upgrade.
-		// (rod) Maybe we could eventually do a search to find out if
-		// there is a potential upgrade
-		if specifiedVersion == "" {
+		if deployed.Chart == nil || deployed.Chart.Metadata == nil {
 			upgrade.Add(release)
 			continue
 		}
-
-		deployedVersion := releasesMap[release].Chart.Metadata.Version
+		deployedVersion := deployed.Chart.Metadata.Version
+
+		// An exact version is up to date when it is the deployed one. No
+		// version means the latest one, we must asssume that we will
+		// potentially upgrade.
+		if spec.constraint == "" {
+			if spec.Version() == "" || spec.Version() != deployedVersion {
+				upgrade.Add(release)
+			}
+			continue
+		}
 		deployedSemver, err := semver.NewVersion(deployedVersion)
 		if err != nil {
 			fmt.Printf("Error: Failed to parse semver `%s`\n", deployedVersion)
 			return nil, err
 		}
 
-		constraint := "= " + specifiedVersion
-		equalConstraint, err := semver.NewConstraint(constraint)
+		rangeConstraint, err := semver.NewConstraint(spec.constraint)
 		if err != nil {
-			fmt.Printf("Error: Failed to create constraint `%s`\n", constraint)
+			fmt.Printf("Error: Failed to create constraint `%s`\n", spec.constraint)
 			return nil, err
 		}
 
-		// If version deployed == specified
-		if equalConstraint.Check(deployedSemver) {
-			// nothing to do, but status not yet known
-			continue
+		// If version deployed satisfies the range
+		if rangeConstraint.Check(deployedSemver) {
+			if !latest || spec.Version() == deployedVersion {
+				// nothing to do, but status not yet known
+				continue
+			}
 		}
 
-		// If version deployed != specified
+		// If version deployed does not satisfy the range or is not the latest
 		upgrade.Add(release)
 	}
 	return upgrade, nil
 }
 
+// checkChartNames ensures the known releases are not upgraded to a chart
+// different from the deployed one, unless allowed
+func checkChartNames(known mapset.Set, releasesMap map[string]*release.Release, specifiedReleasesMap map[string]Release, allow bool) error {
+
+	for r := range known.Iter() {
+		name := r.(string)
+		spec := specifiedReleasesMap[name].Spec
+		deployedName, chartName, changed := chartChange(spec, releasesMap[name])
+		if !changed {
+			continue
+		}
+
+		if !allow {
+			return fmt.Errorf("Release %s is deployed from chart %s but the plan specifies chart %s (use --allow-chart-change to proceed)",
+				spec.name, deployedName, chartName)
+		}
+		fmt.Printf("Warning: Release %s will change from chart %s to chart %s\n", spec.name, deployedName, chartName)
+	}
+	return nil
+}
+
+// chartChange returns the name of the deployed chart and of the specified
+// one, and whether they differ. The change is unknown when the specified
+// chart name cannot be known before fetching it.
+func chartChange(spec ReleaseSpec, deployed *release.Release) (string, string, bool) {
+	if deployed.Chart == nil || deployed.Chart.Metadata == nil {
+		return "", "", false
+	}
+	chartName := spec.chartName()
+	if chartName == "" || chartName == deployed.Chart.Metadata.Name {
+		return deployed.Chart.Metadata.Name, chartName, false
+	}
+	return deployed.Chart.Metadata.Name, chartName, true
+}
+
+// resolveCharts resolves the version ranges of the named releases into the
+// concrete versions to deploy and computes the digest of their local charts.
+// The charts of the unresolved repositories are left as specified.
+func resolveCharts(names mapset.Set, releases map[string]Release, unresolved []string) (map[string]Release, error) {
+	unresolvedSet := mapset.NewSet()
+	for _, name := range unresolved {
+		unresolvedSet.Add(name)
+	}
+	for _, name := range sortedNames(names) {
+		release := releases[name]
+		if err := release.Spec.loadLocalChart(); err != nil {
+			fmt.Printf("Error: %s\n", err)
+			return nil, err
+		}
+		if err := release.Spec.digestLocalChart(); err != nil {
+			fmt.Printf("Error: %s\n", err)
+			return nil, err
+		}
+		if repoName, _, err := helm.SplitChart(release.Spec.Chart); err == nil && !release.Spec.isLocal() && unresolvedSet.Contains(repoName) {
+			fmt.Printf("The version of %s is not resolved, repository %s is not added yet\n", release.Name(), repoName)
+			continue
+		}
+		if err := release.Spec.resolveVersion(resolveVersion); err != nil {
+			fmt.Printf("Error: Failed to resolve the version of %s: %s\n", release.Name(), err)
+			return nil, err
+		}
+		if err := release.Spec.verifyDigest(resolveVersion); err != nil {
+			fmt.Printf("Error: Failed to verify the chart of %s: %s\n", release.Name(), err)
+			return nil, err
+		}
+		releases[name] = release
+	}
+	return releases, nil
+}
+
 // Conform will apply the name and namespaces to the contained Releases
 func (p *Plan) conform() {
 	for namespaceName, ns := range p.Namespaces {
@@ -264,6 +681,48 @@ func (p *Plan) conform() {
 	}
 }
 
+// resolveLocalPaths resolves the paths of the local charts against the
+// plan directory
+func (p *Plan) resolveLocalPaths(dir string) error {
+	for _, ns := range p.Namespaces {
+		for releaseName, release := range ns.Releases {
+			if err := release.Spec.resolveLocalPath(dir); err != nil {
+				return err
+			}
+			ns.Releases[releaseName] = release
+		}
+	}
+	return nil
+}
+
+// DependencyBuildCommands returns the commands building the dependencies of
+// the local charts of the selected releases that declare requirements
+func (p *Plan) DependencyBuildCommands(namespaces []string, selector Selector) ([]executor.Command, error) {
+	_, releases := p.specifiedReleases(namespaceFilter(namespaces))
+	selected, err := selector.selectReleases(releases)
+	if err != nil {
+		return nil, err
+	}
+	charts := map[string]bool{}
+	for _, name := range sortedNames(selected) {
+		if spec := releases[name].Spec; spec.hasRequirements() {
+			charts[spec.localPath] = true
+		}
+	}
+
+	names := []string{}
+	for chart := range charts {
+		names = append(names, chart)
+	}
+	sort.Strings(names)
+
+	cmds := []executor.Command{}
+	for _, chart := range names {
+		cmds = append(cmds, executor.NewExecutableCommand("helm", []string{"dependency", "build", chart}))
+	}
+	return cmds, nil
+}
+
 func (p Plan) verify() (bool, error) {
 
 	if len(p.Namespaces) == 1 {
@@ -301,16 +760,27 @@ func Load(planPath string) (*Plan, error) {
 	if err != nil {
 		return nil, err
 	}
-	return loadString(content)
+	plan, err := load(content, filepath.Dir(planPath))
+	if plan != nil {
+		plan.path = planPath
+	}
+	return plan, err
 }
 
 func loadString(content []byte) (*Plan, error) {
+	return load(content, "")
+}
+
+// load reads the plan content, the paths of its local charts being relative
+// to dir
+func load(content []byte, dir string) (*Plan, error) {
 	var plan Plan
 	err := yaml.Unmarshal(content, &plan)
 	if err != nil {
-		fmt.Println("err:%v\n", err)
+		fmt.Printf("err:%v\n", err)
 		return nil, err
 	}
+	plan.digest = digest(content)
 
 	valid, err := plan.verify()
 	if !valid {
@@ -319,6 +789,10 @@ func loadString(content []byte) (*Plan, error) {
 
 	plan.conform()
 
+	if err := plan.resolveLocalPaths(dir); err != nil {
+		return &plan, err
+	}
+
 	return &plan, nil
 }
 
@@ -340,7 +814,11 @@ func (r Release) Name() string {
 
 // Deps returns a list of releases on which the current release depends
 func (r Release) Deps() []string {
-	return r.Depends
+	deps := make([]string, len(r.Depends))
+	for i, d := range r.Depends {
+		deps[i] = d.Name
+	}
+	return deps
 }
 
 // Version returns the version of the release
@@ -352,6 +830,28 @@ func (r *Release) SetRelease(helmRelease *release.Release) {
 	r.release = helmRelease
 }
 
+// Deployed returns the deployed release bound to the release, nil if the
+// release is not deployed
+func (r Release) Deployed() *release.Release {
+	return r.release
+}
+
+// deployedRevision returns the currently deployed revision of the release or
+// 0 if the release is not deployed
+func (r Release) deployedRevision() int32 {
+	if r.release == nil {
+		return 0
+	}
+	return r.release.Version
+}
+
+// rollbackRevision returns the revision to rollback to when undoing an
+// upgrade, the one deployed before the upgrade, or 0 if the upgrade can only
+// be undone by a delete
+func (r Release) rollbackRevision() int32 {
+	return r.deployedRevision()
+}
+
 // --- Dependency resolution --------------------------------------------------
 
 type GraphNode interface {
@@ -361,29 +861,91 @@ type GraphNode interface {
 
 type dependencyGraph []GraphNode
 
-func (g dependencyGraph) print() {
+// sortedNode is a node ordered within its level by priority and namespace
+type sortedNode interface {
+	sortKey() (priority int, namespace string)
+}
 
-	for _, n := range g {
-		fmt.Printf("%s -> %s\n", n.Name(), n.Deps())
+func (r Release) sortKey() (int, string) {
+	return r.Priority, r.Spec.namespace
+}
+
+// sortLevel orders the nodes of a level by decreasing priority, then by
+// namespace and name, for the operations to be performed in the same order
+// on every run
+func sortLevel(level dependencyGraph) {
+	key := func(n GraphNode) (int, string) {
+		if s, ok := n.(sortedNode); ok {
+			return s.sortKey()
+		}
+		return 0, ""
+	}
+	sort.Slice(level, func(i, j int) bool {
+		pi, nsi := key(level[i])
+		pj, nsj := key(level[j])
+		if pi != pj {
+			return pi > pj
+		}
+		if nsi != nsj {
+			return nsi < nsj
+		}
+		return level[i].Name() < level[j].Name()
+	})
+}
+
+// sortedNames returns the sorted names of a set of release keys
+func sortedNames(s mapset.Set) []string {
+	names := []string{}
+	for n := range s.Iter() {
+		names = append(names, n.(string))
 	}
+	sort.Strings(names)
+	return names
 }
 
 // resolveDependencies uses topological sort to resolve the node dependencies
 // http://dnaeon.github.io/dependency-graph-resolution-algorithm-in-go/
 func resolveDependencies(graph dependencyGraph) (dependencyGraph, error) {
 
+	levels, err := resolveLevels(graph)
+	if err != nil {
+		return nil, err
+	}
+
+	var resolved dependencyGraph
+	for _, level := range levels {
+		resolved = append(resolved, level...)
+	}
+	return resolved, nil
+}
+
+// resolveLevels groups the nodes by execution level: the nodes of a level
+// only depend on the nodes of the previous levels. The levels are sorted by
+// sortLevel. The dependencies on nodes not in the graph and the cycles are
+// reported by a dependencyError.
+func resolveLevels(graph dependencyGraph) ([]dependencyGraph, error) {
+
 	// A map that contains the name to the actual object
 	nodeNames := make(map[string]GraphNode)
+	for _, node := range graph {
+		nodeNames[node.Name()] = node
+	}
 
 	// A map that contains the node and their dependencies
 	nodeDependencies := make(map[string]mapset.Set)
 
+	// The dependencies on nodes not in the graph would never be ready, they
+	// are reported instead of being mistaken for a cycle
+	dangling := map[string][]string{}
+
 	// Populate the maps
 	for _, node := range graph {
-		nodeNames[node.Name()] = node
-
 		dependencySet := mapset.NewSet()
 		for _, dep := range node.Deps() {
+			if _, ok := nodeNames[dep]; !ok {
+				dangling[node.Name()] = append(dangling[node.Name()], dep)
+				continue
+			}
 			dependencySet.Add(dep)
 		}
 		nodeDependencies[node.Name()] = dependencySet
@@ -392,7 +954,7 @@ func resolveDependencies(graph dependencyGraph) (dependencyGraph, error) {
 	// Iteratively find and remove nodes from the graph which have no dependencies.
 	// If at some point there are still nodes in the graph and we cannot find
 	// nodes without dependencies, that means we have a circular dependency
-	var resolved dependencyGraph
+	var levels []dependencyGraph
 	for len(nodeDependencies) != 0 {
 		// Get all the nodes from the graph which have no dependecies
 		readySet := mapset.NewSet()
@@ -404,18 +966,17 @@ func resolveDependencies(graph dependencyGraph) (dependencyGraph, error) {
 
 		// If there aren't any ready nodes, then we have a circular dependency
 		if readySet.Cardinality() == 0 {
-			var g []GraphNode
-			for name := range nodeDependencies {
-				g = append(g, nodeNames[name])
-			}
-			return g, errors.New("Circular dependency found")
+			return nil, dependencyError{dangling: dangling, cycles: findCycles(nodeDependencies)}
 		}
 
-		// Remove the ready nodes and add them to the resolved graph
+		// Remove the ready nodes and add them to the level
+		var level dependencyGraph
 		for name := range readySet.Iter() {
 			delete(nodeDependencies, name.(string))
-			resolved = append(resolved, nodeNames[name.(string)])
+			level = append(level, nodeNames[name.(string)])
 		}
+		sortLevel(level)
+		levels = append(levels, level)
 
 		// Also make sure to remove the ready nodes from the remaining node
 		// dependencies as well
@@ -425,5 +986,8 @@ func resolveDependencies(graph dependencyGraph) (dependencyGraph, error) {
 		}
 	}
 
-	return resolved, nil
+	if len(dangling) != 0 {
+		return nil, dependencyError{dangling: dangling}
+	}
+	return levels, nil
 }
@@ -2,171 +2,217 @@ package plan
 
 import (
 	"fmt"
-	"reflect"
+	"os"
+	"path/filepath"
 	"strconv"
-)
+	"strings"
 
-type InstallFlags struct {
-	CAFile       string   `json:"ca-file"`
-	Cert_file    string   `json:"cert-file"`
-	Devel        string   `json:"devel"`
-	Dry_run      bool     `json:"dry-run"`
-	Key_file     string   `json:"key-file"`
-	Keyring      string   `json:"keyring"`
-	Name         string   `json:"name"`
-	NameTemplate string   `json:"name-template"`
-	Namespace    string   `json:"namespace"`
-	No_hooks     bool     `json:"no-hooks"`
-	Replace      bool     `json:"replace"`
-	Repo         string   `json:"repo"`
-	Set          []string `json:"set"`
-	Timeout      int      `json:"timeout"`
-	TLS          bool     `json:"tls"`
-	TLS_CA_cert  string   `json:"tls-ca-cert"`
-	TLS_cert     string   `json:"tls-cert"`
-	TLS_key      string   `json:"tls-key"`
-	TLS_verify   bool     `json:"tls-verify"`
-	Values       []string `json:"values"`
-	Verify       bool     `json:"verify"`
-	Version      string   `json:"version"`
-	Wait         bool     `json:"wait"`
-}
-
-type UpgradeFlags struct {
-	CAFile        string   `json:"ca-file"`
-	Cert_file     string   `json:"cert-file"`
-	Devel         string   `json:"devel"`
-	Dry_run       bool     `json:"dry-run"`
-	Force         bool     `json:"force"`
-	Install       bool     `json:"install"`
-	Key_file      string   `json:"key-file"`
-	Keyring       string   `json:"keyring"`
-	Namespace     string   `json:"namespace"`
-	No_hooks      bool     `json:"no-hooks"`
-	Recreate_pods bool     `json:"recreate-pods"`
-	Repo          string   `json:"repo"`
-	Reset_values  bool     `json:"reset-values"`
-	Reuse_values  bool     `json:"reuse-values"`
-	Set           []string `json:"set"`
-	Timeout       int      `json:"timeout"`
-	TLS           bool     `json:"tls"`
-	TLS_CA_cert   string   `json:"tls-ca-cert"`
-	TLS_cert      string   `json:"tls-cert"`
-	TLS_key       string   `json:"tls-key"`
-	TLS_verify    bool     `json:"tls-verify"`
-	Values        []string `json:"values"`
-	Verify        bool     `json:"verify"`
-	Version       string   `json:"version"`
-	Wait          bool     `json:"wait"`
-}
-
-type DeleteFlags struct {
-	Dry_run     bool   `json:"dry-run"`
-	No_hooks    bool   `json:"no-hooks"`
-	Purge       bool   `json:"purge"`
-	Timeout     int    `json:"timeout"`
-	TLS         bool   `json:"tls"`
-	TLS_CA_cert string `json:"tls-ca-cert"`
-	TLS_cert    string `json:"tls-cert"`
-	TLS_key     string `json:"tls-key"`
-	TLS_verify  bool   `json:"tls-verify"`
-}
-
-type RollbackFlags struct {
-	Dry_run       bool   `json:"dry-run"`
-	Force         bool   `json:"force"`
-	No_hooks      bool   `json:"no-hooks"`
-	Recreate_pods bool   `json:"recreate-pods"`
-	Timeout       int    `json:"timeout"`
-	TLS           bool   `json:"tls"`
-	TLS_CA_cert   string `json:"tls-ca-cert"`
-	TLS_cert      string `json:"tls-cert"`
-	TLS_key       string `json:"tls-key"`
-	TLS_verify    bool   `json:"tls-verify"`
-	Wait          bool   `json:"wait"`
-}
-
-type ReleaseOperationsFlags struct {
-	Install  InstallFlags  `json:"install"`
-	Upgrade  UpgradeFlags  `json:"upgrade"`
-	Delete   DeleteFlags   `json:"delete"`
-	Rollback RollbackFlags `json:"rollback"`
-}
+	"github.com/Masterminds/semver"
+	"k8s.io/helm/pkg/chartutil"
+	"k8s.io/helm/pkg/proto/hapi/chart"
+	"k8s.io/helm/pkg/repo"
+
+	"github.com/rodcloutier/helm-steer/pkg/helm"
+)
 
 type ReleaseSpec struct {
 	name      string
 	namespace string
+	// The version range when the specified version was resolved
+	constraint string
+	// The chart digest pinned by the lock file
+	digest string
+	// The path of a local chart, resolved against the plan directory
+	localPath string
+	// The metadata of a local chart directory
+	localChart *chart.Metadata
+	// The content digest of a local chart directory
+	localDigest string
 
 	// Exported to json values
 	Chart string                 `json:"chart"`
 	Flags ReleaseOperationsFlags `json:"flags"`
 }
 
-func buildHelmCmdFlags(i interface{}) []string {
-	var cmd []string
+func (r *ReleaseSpec) Conform(namespace, name string) error {
+
+	r.name = name
+	r.namespace = namespace
+
+	r.Flags.Install.Name = name
+	r.Flags.Install.Namespace = namespace
+
+	r.Flags.Upgrade.Namespace = namespace
 
-	val := reflect.Indirect(reflect.ValueOf(i))
+	return nil
+}
 
-	for i := 0; i < val.NumField(); i++ {
-		typeField := val.Type().Field(i)
-		name := typeField.Tag.Get("json")
+func (r ReleaseSpec) Version() string {
+	if r.localChart != nil {
+		return r.localChart.Version
+	}
+	return r.Flags.Install.Version
+}
 
-		name = "--" + name
-		valueField := val.Field(i)
+// chartName returns the name of the chart or an empty string when it cannot
+// be known before fetching the chart (url or archive)
+func (r ReleaseSpec) chartName() string {
+	if r.localChart != nil {
+		return r.localChart.Name
+	}
+	_, name, err := helm.SplitChart(r.Chart)
+	if err != nil {
+		return ""
+	}
+	return name
+}
 
-		if !valueField.CanInterface() {
-			// The value is not exported, skip it
-			continue
+// resolveLocalPath resolves the path of a local chart directory or packaged
+// chart relative to the plan directory. Like helm, an existing path has
+// precedence over a repository chart.
+func (r *ReleaseSpec) resolveLocalPath(dir string) error {
+	path := r.Chart
+	if !filepath.IsAbs(path) {
+		abs, err := filepath.Abs(filepath.Join(dir, path))
+		if err != nil {
+			return err
 		}
-		value := reflect.ValueOf(valueField.Interface())
-
-		switch kind := typeField.Type.Kind(); kind {
-		case reflect.Bool:
-			if value.Bool() == true {
-				cmd = append(cmd, name)
-			}
-		case reflect.String:
-			v := value.String()
-			if v != "" {
-				cmd = append(cmd, name, v)
-			}
-
-		case reflect.Slice:
-			for ii := 0; ii < value.Len(); ii++ {
-				v := value.Index(ii).String()
-				if v != "" {
-					cmd = append(cmd, name, v)
-				}
-			}
+		path = abs
+	}
+	if strings.HasPrefix(r.Chart, ".") || filepath.IsAbs(r.Chart) {
+		r.localPath = path
+		return nil
+	}
+	if _, err := os.Stat(path); err == nil {
+		r.localPath = path
+	}
+	return nil
+}
+
+// isLocal reports whether the chart is a local directory or packaged chart
+func (r ReleaseSpec) isLocal() bool {
+	return r.localPath != ""
+}
+
+// chartRef returns the chart passed to helm, the resolved path of a local
+// chart
+func (r ReleaseSpec) chartRef() string {
+	if r.isLocal() {
+		return r.localPath
+	}
+	return r.Chart
+}
+
+// loadLocalChart reads the Chart.yaml of a local chart directory or
+// packaged chart
+func (r *ReleaseSpec) loadLocalChart() error {
+	if !r.isLocal() || r.localChart != nil {
+		return nil
+	}
+	info, err := os.Stat(r.localPath)
+	if err != nil {
+		return fmt.Errorf("Failed to load local chart %s: %s", r.Chart, err)
+	}
+	if info.IsDir() {
+		metadata, err := chartutil.LoadChartfile(filepath.Join(r.localPath, "Chart.yaml"))
+		if err != nil {
+			return fmt.Errorf("Failed to load local chart %s: %s", r.Chart, err)
 		}
+		r.localChart = metadata
+		return nil
+	}
+	c, err := chartutil.Load(r.localPath)
+	if err != nil {
+		return fmt.Errorf("Failed to load local chart %s: %s", r.Chart, err)
 	}
+	r.localChart = c.Metadata
+	return nil
+}
 
-	return cmd
+// hasRequirements reports whether the local chart directory declares
+// dependencies, a packaged chart already holds them
+func (r ReleaseSpec) hasRequirements() bool {
+	if !r.isLocal() {
+		return false
+	}
+	_, err := os.Stat(filepath.Join(r.localPath, "requirements.yaml"))
+	return err == nil
 }
 
-func (r *ReleaseSpec) Conform(namespace, name string) error {
+// digestLocalChart computes the content digest of a local chart
+func (r *ReleaseSpec) digestLocalChart() error {
+	if r.localChart == nil {
+		return nil
+	}
+	c, err := chartutil.Load(r.localPath)
+	if err != nil {
+		return fmt.Errorf("Failed to load local chart %s: %s", r.Chart, err)
+	}
+	r.localDigest = helm.ChartDigest(c)
+	return nil
+}
 
-	r.name = name
-	r.namespace = namespace
+// resolveVersion replaces a version range by the concrete version returned
+// by resolve. Exact versions are left untouched.
+func (r *ReleaseSpec) resolveVersion(resolve func(chart, constraint string) (*repo.ChartVersion, error)) error {
 
-	r.Flags.Install.Name = name
-	r.Flags.Install.Namespace = namespace
+	version := r.Version()
+	if version == "" || r.localChart != nil {
+		return nil
+	}
+	if _, err := semver.NewVersion(version); err == nil {
+		return nil
+	}
 
-	r.Flags.Upgrade.Namespace = namespace
+	resolved, err := resolve(r.Chart, version)
+	if err != nil {
+		return err
+	}
 
+	r.pinVersion(resolved.Version)
 	return nil
 }
 
-func (r ReleaseSpec) Version() string {
-	return r.Flags.Install.Version
+// verifyDigest ensures the digest of the chart version in the repository
+// index is still the one pinned by the lock file
+func (r *ReleaseSpec) verifyDigest(resolve func(chart, constraint string) (*repo.ChartVersion, error)) error {
+	if r.digest == "" {
+		return nil
+	}
+	cv, err := resolve(r.Chart, "= "+r.Version())
+	if err != nil {
+		return err
+	}
+	if cv.Digest != r.digest {
+		return fmt.Errorf("The digest of chart %s-%s changed since it was locked", r.Chart, r.Version())
+	}
+	return nil
+}
+
+// pinVersion replaces the specified version of the install and upgrade,
+// keeping a version range as the constraint to satisfy
+func (r *ReleaseSpec) pinVersion(version string) {
+	specified := r.Version()
+	if _, err := semver.NewVersion(specified); specified != "" && err != nil {
+		r.constraint = specified
+	}
+	r.Flags.Install.Version = version
+	// Without a version, helm upgrades to the latest chart instead of the
+	// resolved one
+	if r.Flags.Upgrade.Version == "" || r.Flags.Upgrade.Version == specified {
+		r.Flags.Upgrade.Version = version
+	}
 }
 
 // String returns the string representation of a ReleaseSpec
 func (r ReleaseSpec) String() string {
 
 	chart := r.Chart
-	if r.Flags.Install.Version != "" {
-		chart = fmt.Sprintf("%s-%s", chart, r.Flags.Install.Version)
+	if r.Version() != "" {
+		chart = fmt.Sprintf("%s-%s", chart, r.Version())
+	}
+	if r.constraint != "" {
+		chart = fmt.Sprintf("%s (%s)", chart, r.constraint)
 	}
 
 	return fmt.Sprintf("%s chart: %s namespace: %s", r.name, chart, r.namespace)
@@ -174,24 +220,24 @@ func (r ReleaseSpec) String() string {
 
 func (r *ReleaseSpec) installCmd() []string {
 	args := []string{"install"}
-	args = append(args, buildHelmCmdFlags(r.Flags.Install)...)
-	return append(args, r.Chart)
+	args = append(args, flagArgs(r.Flags.Install.flags(), r.Flags.Install.ExtraArgs)...)
+	return append(args, r.chartRef())
 }
 
 func (r *ReleaseSpec) upgradeCmd() []string {
 	args := []string{"upgrade"}
-	args = append(args, buildHelmCmdFlags(r.Flags.Upgrade)...)
-	return append(args, r.name, r.Chart)
+	args = append(args, flagArgs(r.Flags.Upgrade.flags(), r.Flags.Upgrade.ExtraArgs)...)
+	return append(args, r.name, r.chartRef())
 }
 
 func (r *ReleaseSpec) rollbackCmd(revision int32) []string {
 	args := []string{"rollback"}
-	args = append(args, buildHelmCmdFlags(r.Flags.Rollback)...)
+	args = append(args, flagArgs(r.Flags.Rollback.flags(), r.Flags.Rollback.ExtraArgs)...)
 	return append(args, r.name, strconv.Itoa(int(revision)))
 }
 
 func (r *ReleaseSpec) deleteCmd() []string {
 	args := []string{"delete"}
-	args = append(args, buildHelmCmdFlags(r.Flags.Delete)...)
+	args = append(args, flagArgs(r.Flags.Delete.flags(), r.Flags.Delete.ExtraArgs)...)
 	return append(args, r.name)
 }
@@ -0,0 +1,224 @@
+package plan
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/rodcloutier/helm-steer/pkg/executor"
+	"github.com/rodcloutier/helm-steer/pkg/helm"
+)
+
+// The function returning the locally configured repositories
+var configuredRepositories = helm.Repositories
+
+// Repository is a chart repository used by the plan releases
+type Repository struct {
+	Name     string    `json:"name"`
+	URL      string    `json:"url"`
+	CAFile   string    `json:"ca-file"`
+	CertFile string    `json:"cert-file"`
+	KeyFile  string    `json:"key-file"`
+	Username SecretRef `json:"username"`
+	Password SecretRef `json:"password"`
+}
+
+// SecretRef is a value read from an environment variable or a file to
+// avoid storing credentials in the plan
+type SecretRef struct {
+	Env  string `json:"env"`
+	File string `json:"file"`
+}
+
+// value returns the referenced value or an empty string when no reference is
+// specified
+func (s SecretRef) value() (string, error) {
+	switch {
+	case s.Env != "":
+		v, ok := os.LookupEnv(s.Env)
+		if !ok {
+			return "", fmt.Errorf("Environment variable %s is not set", s.Env)
+		}
+		return v, nil
+	case s.File != "":
+		content, err := ioutil.ReadFile(s.File)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimSpace(string(content)), nil
+	}
+	return "", nil
+}
+
+// credentials returns the username and password of the repository, empty
+// when not specified
+func (r Repository) credentials() (string, string, error) {
+	username, err := r.Username.value()
+	if err != nil {
+		return "", "", err
+	}
+	password, err := r.Password.value()
+	if err != nil {
+		return "", "", err
+	}
+	return username, password, nil
+}
+
+// withoutUserinfo returns the url without its credentials, if any
+func withoutUserinfo(rawurl string) string {
+	u, err := url.Parse(rawurl)
+	if err != nil || u.User == nil {
+		return rawurl
+	}
+	u.User = nil
+	return u.String()
+}
+
+// configuredIn reports whether the repository is already configured with
+// the same url
+func (r Repository) configuredIn(configured map[string]string) bool {
+	// The configured url may hold credentials, the plan one must not
+	current, ok := configured[r.Name]
+	return ok && withoutUserinfo(current) == withoutUserinfo(r.URL)
+}
+
+// The function adding a repository to the repositories file
+var addRepository = helm.AddRepository
+
+// addRepositoryCommand adds a repository with credentials by writing it to
+// the repositories file, keeping the credentials out of the helm arguments
+// visible to the other users of the host
+type addRepositoryCommand struct {
+	entry helm.RepositoryEntry
+}
+
+func (c addRepositoryCommand) String() string {
+	return fmt.Sprintf("add repository %s %s with credentials to %s", c.entry.Name, c.entry.URL, helm.Home().RepositoryFile())
+}
+
+func (c addRepositoryCommand) Run(w io.Writer) (executor.Result, error) {
+	if err := addRepository(c.entry); err != nil {
+		return executor.Result{ExitCode: 1, Stderr: []byte(err.Error())}, err
+	}
+	return executor.Result{}, nil
+}
+
+// RepositoryCommands returns the commands adding the plan repositories that
+// are not configured locally followed by a refresh of the repositories
+// indexes
+func (p *Plan) RepositoryCommands() ([]executor.Command, error) {
+
+	if len(p.Repositories) == 0 {
+		return nil, nil
+	}
+
+	configured, err := configuredRepositories()
+	if err != nil {
+		return nil, err
+	}
+
+	cmds := []executor.Command{}
+	for _, r := range p.Repositories {
+		if r.Name == "" || r.URL == "" {
+			return nil, errors.New("A repository requires a name and an url")
+		}
+		username, password, err := r.credentials()
+		if err != nil {
+			return nil, fmt.Errorf("Invalid credentials for repository %s: %s", r.Name, err)
+		}
+
+		if r.configuredIn(configured) {
+			continue
+		}
+		_, ok := configured[r.Name]
+		// The repositories file replaces the repository of the same name,
+		// the index is downloaded by the update
+		if username != "" || password != "" {
+			cmds = append(cmds, addRepositoryCommand{entry: helm.RepositoryEntry{
+				Name:     r.Name,
+				URL:      r.URL,
+				Username: username,
+				Password: password,
+				CAFile:   r.CAFile,
+				CertFile: r.CertFile,
+				KeyFile:  r.KeyFile,
+			}})
+			continue
+		}
+		if ok {
+			cmds = append(cmds, executor.NewExecutableCommand("helm", []string{"repo", "remove", r.Name}))
+		}
+
+		args := []string{"repo", "add"}
+		if r.CAFile != "" {
+			args = append(args, "--ca-file", r.CAFile)
+		}
+		if r.CertFile != "" {
+			args = append(args, "--cert-file", r.CertFile)
+		}
+		if r.KeyFile != "" {
+			args = append(args, "--key-file", r.KeyFile)
+		}
+		args = append(args, r.Name, r.URL)
+		cmds = append(cmds, executor.NewExecutableCommand("helm", args))
+	}
+
+	return append(cmds, executor.NewExecutableCommand("helm", []string{"repo", "update"})), nil
+}
+
+// PendingRepositories returns the names of the plan repositories that are
+// not configured locally, whose index is only available once the
+// RepositoryCommands are run
+func (p *Plan) PendingRepositories() ([]string, error) {
+
+	if len(p.Repositories) == 0 {
+		return nil, nil
+	}
+
+	configured, err := configuredRepositories()
+	if err != nil {
+		return nil, err
+	}
+
+	names := []string{}
+	for _, r := range p.Repositories {
+		if !r.configuredIn(configured) {
+			names = append(names, r.Name)
+		}
+	}
+	return names, nil
+}
+
+// VerifyRepositories ensures the repository of every targeted release chart
+// is either declared in the plan or configured locally
+func (p *Plan) VerifyRepositories(namespaces []string) error {
+
+	configured, err := configuredRepositories()
+	if err != nil {
+		return err
+	}
+	for _, r := range p.Repositories {
+		configured[r.Name] = r.URL
+	}
+
+	for _, name := range p.NamespaceNames(namespaces) {
+		for releaseName, release := range p.Namespaces[name].Releases {
+			if release.Spec.isLocal() {
+				continue
+			}
+			repoName, _, err := helm.SplitChart(release.Spec.Chart)
+			if err != nil {
+				// Not a repository chart (url or archive)
+				continue
+			}
+			if _, ok := configured[repoName]; !ok {
+				return fmt.Errorf("Repository %s of release %s is neither declared in the plan nor configured", repoName, releaseName)
+			}
+		}
+	}
+	return nil
+}
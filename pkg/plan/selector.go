@@ -0,0 +1,123 @@
+package plan
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/deckarep/golang-set"
+)
+
+// Selector selects the releases of the plan to process. An empty selector
+// selects all the releases.
+type Selector struct {
+	// The names of the releases to select
+	Releases []string
+	// The label requirements (key=value or key!=value) the releases must
+	// all satisfy, including the named ones
+	Labels []string
+	// The names of the releases to exclude, even if the dependencies or
+	// dependents would select them
+	Exclude []string
+	// Also select the releases on which the selected ones depend
+	WithDependencies bool
+	// Also select the releases depending on the selected ones
+	WithDependents bool
+}
+
+// matches reports whether the labels satisfy all the requirements
+func matches(requirements []string, labels map[string]string) (bool, error) {
+	for _, req := range requirements {
+		if i := strings.Index(req, "!="); i > 0 {
+			if labels[req[:i]] == req[i+2:] {
+				return false, nil
+			}
+			continue
+		}
+		if i := strings.Index(req, "="); i > 0 {
+			value, ok := labels[req[:i]]
+			if !ok || value != req[i+1:] {
+				return false, nil
+			}
+			continue
+		}
+		return false, fmt.Errorf("Invalid selector `%s`, expected key=value or key!=value", req)
+	}
+	return true, nil
+}
+
+// selectReleases returns the keys of the selected releases
+func (s Selector) selectReleases(releases map[string]Release) (mapset.Set, error) {
+
+	selected := mapset.NewSet()
+	byName := map[string]string{}
+	for key, r := range releases {
+		byName[r.Name()] = key
+	}
+
+	// The named releases must also match the labels
+	named := mapset.NewSet()
+	for _, name := range s.Releases {
+		key, ok := byName[name]
+		if !ok {
+			return nil, fmt.Errorf("Unknown release %s", name)
+		}
+		named.Add(key)
+	}
+	for key, r := range releases {
+		if len(s.Releases) != 0 && !named.Contains(key) {
+			continue
+		}
+		ok, err := matches(s.Labels, r.Labels)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			selected.Add(key)
+		}
+	}
+	// Extend the selection to the graph closure
+	dependents := map[string][]string{}
+	for key, r := range releases {
+		for _, dep := range r.Deps() {
+			if parent, ok := byName[dep]; ok {
+				dependents[parent] = append(dependents[parent], key)
+			}
+		}
+	}
+	next := func(key string) []string {
+		keys := []string{}
+		if s.WithDependencies {
+			for _, dep := range releases[key].Deps() {
+				if parent, ok := byName[dep]; ok {
+					keys = append(keys, parent)
+				}
+			}
+		}
+		if s.WithDependents {
+			keys = append(keys, dependents[key]...)
+		}
+		return keys
+	}
+
+	pending := selected.ToSlice()
+	for len(pending) != 0 {
+		key := pending[0].(string)
+		pending = pending[1:]
+		for _, k := range next(key) {
+			if !selected.Contains(k) {
+				selected.Add(k)
+				pending = append(pending, k)
+			}
+		}
+	}
+
+	// The exclusions win over the closure
+	for _, name := range s.Exclude {
+		key, ok := byName[name]
+		if !ok {
+			return nil, fmt.Errorf("Unknown release %s", name)
+		}
+		selected.Remove(key)
+	}
+	return selected, nil
+}
@@ -0,0 +1,104 @@
+package plan
+
+import (
+	"sort"
+	"time"
+
+	"k8s.io/helm/pkg/proto/hapi/release"
+)
+
+// String returns the string representation of an Action
+func (a Action) String() string {
+	switch a {
+	case actionInstall:
+		return "install"
+	case actionUpgrade:
+		return "upgrade"
+	case actionDelete:
+		return "delete"
+	}
+	return "unchanged"
+}
+
+// ReleaseStatus is the state of a release of the plan and of the deployed
+// release bound to it
+type ReleaseStatus struct {
+	Namespace string
+	Name      string
+	// The chart and version specified by the plan
+	Chart   string
+	Version string
+	// The deployed chart and version, empty when the release is not deployed
+	DeployedChart   string
+	DeployedVersion string
+	Revision        int32
+	Status          string
+	LastDeployed    time.Time
+	// The action a run of the plan would perform
+	Action Action
+}
+
+// Status returns the state of the releases of the targeted namespaces (empty
+// is all namespaces) sorted by namespace and name, with the action a run of
+// the plan would perform on them
+func (p *Plan) Status(namespaces []string, deployed []*release.Release, latest bool) ([]ReleaseStatus, error) {
+
+	isValidNamespace := namespaceFilter(namespaces)
+	specified, specifiedMap := p.specifiedReleases(isValidNamespace)
+	current, currentMap := p.currentReleases(isValidNamespace, deployed)
+
+	specifiedMap, err := resolveCharts(specified, specifiedMap, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	install := specified.Difference(current)
+	known := specified.Intersect(current)
+	specifiedMap = bindReleases(known, specifiedMap, currentMap)
+
+	upgrade, err := extractUpgrades(known, currentMap, specifiedMap, latest)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := []ReleaseStatus{}
+	for r := range specified.Iter() {
+		name := r.(string)
+		rel := specifiedMap[name]
+
+		s := ReleaseStatus{
+			Namespace: rel.Spec.namespace,
+			Name:      rel.Name(),
+			Chart:     rel.Spec.Chart,
+			Version:   rel.Version(),
+			Action:    actionNone,
+		}
+		switch {
+		case install.Contains(name):
+			s.Action = actionInstall
+		case upgrade.Contains(name):
+			s.Action = actionUpgrade
+		}
+
+		if d := rel.Deployed(); d != nil {
+			if d.Chart != nil && d.Chart.Metadata != nil {
+				s.DeployedChart = d.Chart.Metadata.Name
+				s.DeployedVersion = d.Chart.Metadata.Version
+			}
+			s.Revision = d.Version
+			s.Status = d.GetInfo().GetStatus().GetCode().String()
+			if d.Info != nil && d.Info.LastDeployed != nil {
+				s.LastDeployed = time.Unix(d.Info.LastDeployed.Seconds, int64(d.Info.LastDeployed.Nanos))
+			}
+		}
+		statuses = append(statuses, s)
+	}
+
+	sort.Slice(statuses, func(i, j int) bool {
+		if statuses[i].Namespace != statuses[j].Namespace {
+			return statuses[i].Namespace < statuses[j].Namespace
+		}
+		return statuses[i].Name < statuses[j].Name
+	})
+	return statuses, nil
+}
@@ -0,0 +1,147 @@
+package readiness
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"strings"
+
+	"github.com/ghodss/yaml"
+
+	"github.com/rodcloutier/helm-steer/pkg/helm"
+	"github.com/rodcloutier/helm-steer/pkg/kube"
+)
+
+// resource is a kubernetes resource found in a release manifest
+type resource struct {
+	Kind     string `json:"kind"`
+	Metadata struct {
+		Name      string `json:"name"`
+		Namespace string `json:"namespace"`
+	} `json:"metadata"`
+}
+
+// object holds the fields of a resource used to evaluate its readiness
+type object struct {
+	Metadata struct {
+		Generation int64 `json:"generation"`
+	} `json:"metadata"`
+	Spec struct {
+		Replicas    *int32 `json:"replicas"`
+		Completions *int32 `json:"completions"`
+	} `json:"spec"`
+	Status struct {
+		ObservedGeneration int64 `json:"observedGeneration"`
+		Replicas           int32 `json:"replicas"`
+		UpdatedReplicas    int32 `json:"updatedReplicas"`
+		ReadyReplicas      int32 `json:"readyReplicas"`
+		AvailableReplicas  int32 `json:"availableReplicas"`
+		Succeeded          int32 `json:"succeeded"`
+	} `json:"status"`
+}
+
+// The readiness evaluation per supported kind
+var readyFuncs = map[string]func(object) bool{
+	"Deployment":  deploymentReady,
+	"StatefulSet": statefulSetReady,
+	"Job":         jobReady,
+}
+
+type kubeChecker struct {
+	// run executes the command and returns its output
+	run func(entrypoint string, args []string) ([]byte, error)
+}
+
+// NewKubeChecker returns a checker that inspects the Deployments,
+// StatefulSets and Jobs of the release manifest using helm and kubectl
+func NewKubeChecker() Checker {
+	return &kubeChecker{run: output}
+}
+
+func output(entrypoint string, args []string) ([]byte, error) {
+	if entrypoint == "kubectl" {
+		return kube.Output(args...)
+	}
+	result, err := helm.Command(args...).Run(ioutil.Discard)
+	return result.Stdout, err
+}
+
+func (c kubeChecker) Ready(namespace, release string) (bool, error) {
+
+	manifest, err := c.run("helm", []string{"get", "manifest", release})
+	if err != nil {
+		return false, err
+	}
+
+	resources, err := parseManifest(manifest)
+	if err != nil {
+		return false, err
+	}
+
+	for _, r := range resources {
+		ready, ok := readyFuncs[r.Kind]
+		if !ok {
+			continue
+		}
+		ns := r.Metadata.Namespace
+		if ns == "" {
+			ns = namespace
+		}
+		content, err := c.run("kubectl", []string{"get", strings.ToLower(r.Kind), r.Metadata.Name, "--namespace", ns, "--output", "json"})
+		if err != nil {
+			return false, err
+		}
+		var o object
+		if err := json.Unmarshal(content, &o); err != nil {
+			return false, err
+		}
+		if !ready(o) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// parseManifest extracts the resources of a multi documents manifest
+func parseManifest(manifest []byte) ([]resource, error) {
+	resources := []resource{}
+	for _, doc := range strings.Split(string(manifest), "\n---") {
+		if strings.TrimSpace(doc) == "" {
+			continue
+		}
+		var r resource
+		if err := yaml.Unmarshal([]byte(doc), &r); err != nil {
+			return nil, err
+		}
+		if r.Kind == "" {
+			continue
+		}
+		resources = append(resources, r)
+	}
+	return resources, nil
+}
+
+func replicas(o object) int32 {
+	if o.Spec.Replicas == nil {
+		return 1
+	}
+	return *o.Spec.Replicas
+}
+
+func deploymentReady(o object) bool {
+	return o.Status.ObservedGeneration >= o.Metadata.Generation &&
+		o.Status.UpdatedReplicas >= replicas(o) &&
+		o.Status.AvailableReplicas >= replicas(o)
+}
+
+func statefulSetReady(o object) bool {
+	return o.Status.ObservedGeneration >= o.Metadata.Generation &&
+		o.Status.ReadyReplicas >= replicas(o)
+}
+
+func jobReady(o object) bool {
+	completions := int32(1)
+	if o.Spec.Completions != nil {
+		completions = *o.Spec.Completions
+	}
+	return o.Status.Succeeded >= completions
+}
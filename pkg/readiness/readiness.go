@@ -0,0 +1,72 @@
+package readiness
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/rodcloutier/helm-steer/pkg/executor"
+)
+
+// DefaultTimeout is the time waited for a release to be ready when none is
+// specified
+const DefaultTimeout = 300 * time.Second
+
+// The delay between two readiness checks
+var pollInterval = 5 * time.Second
+
+// Checker reports whether the resources of a release are ready
+type Checker interface {
+	Ready(namespace, release string) (bool, error)
+}
+
+// Wait polls the checker until the release is ready or the timeout expires.
+// The errors of the checker are retried, the last one being returned on
+// timeout.
+func Wait(checker Checker, namespace, release string, timeout time.Duration) error {
+	if timeout == 0 {
+		timeout = DefaultTimeout
+	}
+	deadline := time.Now().Add(timeout)
+	for {
+		ready, err := checker.Ready(namespace, release)
+		if err == nil && ready {
+			return nil
+		}
+		if time.Now().Add(pollInterval).After(deadline) {
+			if err != nil {
+				return fmt.Errorf("Timed out after %s waiting for release %s to be ready: %s", timeout, release, err)
+			}
+			return fmt.Errorf("Timed out after %s waiting for release %s to be ready", timeout, release)
+		}
+		time.Sleep(pollInterval)
+	}
+}
+
+type waitCommand struct {
+	checker   Checker
+	namespace string
+	release   string
+	timeout   time.Duration
+}
+
+// NewWaitCommand returns a command that waits for the release to be ready
+func NewWaitCommand(checker Checker, namespace, release string, timeout time.Duration) executor.Command {
+	return &waitCommand{
+		checker:   checker,
+		namespace: namespace,
+		release:   release,
+		timeout:   timeout,
+	}
+}
+
+func (c waitCommand) String() string {
+	return fmt.Sprintf("wait for release %s in namespace %s to be ready", c.release, c.namespace)
+}
+
+func (c waitCommand) Run(w io.Writer) (executor.Result, error) {
+	fmt.Fprintf(w, "Waiting for release %s to be ready\n", c.release)
+	start := time.Now()
+	err := Wait(c.checker, c.namespace, c.release, c.timeout)
+	return executor.Result{Duration: time.Since(start)}, err
+}
@@ -0,0 +1,150 @@
+package readiness
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+type fakeChecker struct {
+	// The number of calls before the release is ready
+	readyAfter int
+	// The number of calls failing first
+	failures int
+	calls    int
+}
+
+func (c *fakeChecker) Ready(namespace, release string) (bool, error) {
+	c.calls++
+	if c.calls <= c.failures {
+		return false, fmt.Errorf("check %d failed", c.calls)
+	}
+	return c.calls > c.readyAfter, nil
+}
+
+func TestWaitReady(t *testing.T) {
+
+	// --- conditions----------------------------------------------------------
+	defer func(d time.Duration) { pollInterval = d }(pollInterval)
+	pollInterval = time.Millisecond
+	checker := &fakeChecker{readyAfter: 2}
+
+	// --- call ---------------------------------------------------------------
+	err := Wait(checker, "foo", "db", time.Second)
+
+	// --- test ---------------------------------------------------------------
+	if err != nil {
+		t.Errorf("expected release to be ready, got `%s`", err)
+	}
+	if checker.calls != 3 {
+		t.Errorf("expected 3 checks, got %d", checker.calls)
+	}
+}
+
+func TestWaitTimeout(t *testing.T) {
+
+	// --- conditions----------------------------------------------------------
+	defer func(d time.Duration) { pollInterval = d }(pollInterval)
+	pollInterval = time.Millisecond
+	checker := &fakeChecker{readyAfter: 1000000}
+
+	// --- call ---------------------------------------------------------------
+	err := Wait(checker, "foo", "db", 10*time.Millisecond)
+
+	// --- test ---------------------------------------------------------------
+	if err == nil {
+		t.Errorf("expected a timeout")
+	}
+}
+
+func TestWaitErrors(t *testing.T) {
+
+	// --- conditions----------------------------------------------------------
+	defer func(d time.Duration) { pollInterval = d }(pollInterval)
+	pollInterval = time.Millisecond
+	checker := &fakeChecker{failures: 2, readyAfter: 2}
+
+	// --- call ---------------------------------------------------------------
+	err := Wait(checker, "foo", "db", time.Second)
+
+	// --- test ---------------------------------------------------------------
+	if err != nil {
+		t.Errorf("expected the failed checks to be retried, got `%s`", err)
+	}
+	if checker.calls != 3 {
+		t.Errorf("expected 3 checks, got %d", checker.calls)
+	}
+
+	// The last error is returned on timeout
+	checker = &fakeChecker{failures: 1000000}
+	err = Wait(checker, "foo", "db", 10*time.Millisecond)
+	if expected := fmt.Sprintf("check %d failed", checker.calls); err == nil || !strings.HasSuffix(err.Error(), expected) {
+		t.Errorf("expected the error to end with `%s`, got `%v`", expected, err)
+	}
+}
+
+const manifest = `
+---
+# Source: db/templates/service.yaml
+apiVersion: v1
+kind: Service
+metadata:
+  name: db
+---
+# Source: db/templates/deployment.yaml
+apiVersion: extensions/v1beta1
+kind: Deployment
+metadata:
+  name: db
+---
+# Source: db/templates/job.yaml
+apiVersion: batch/v1
+kind: Job
+metadata:
+  name: db-migrate
+  namespace: other
+`
+
+func TestKubeCheckerReady(t *testing.T) {
+
+	// --- conditions----------------------------------------------------------
+	objects := map[string]string{
+		"deployment db --namespace foo":    `{"metadata": {"generation": 2}, "spec": {"replicas": 2}, "status": {"observedGeneration": 2, "updatedReplicas": 2, "availableReplicas": 2}}`,
+		"job db-migrate --namespace other": `{"status": {"succeeded": 0}}`,
+	}
+	checker := kubeChecker{
+		run: func(entrypoint string, args []string) ([]byte, error) {
+			if entrypoint == "helm" {
+				return []byte(manifest), nil
+			}
+			key := strings.Join(args[1:5], " ")
+			o, ok := objects[key]
+			if !ok {
+				return nil, errors.New("not found: " + key)
+			}
+			return []byte(o), nil
+		},
+	}
+
+	// --- call ---------------------------------------------------------------
+	ready, err := checker.Ready("foo", "db")
+
+	// --- test ---------------------------------------------------------------
+	if err != nil {
+		t.Fatalf("unexpected error `%s`", err)
+	}
+	if ready {
+		t.Errorf("expected the release not to be ready while the job is running")
+	}
+
+	objects["job db-migrate --namespace other"] = `{"status": {"succeeded": 1}}`
+	ready, err = checker.Ready("foo", "db")
+	if err != nil {
+		t.Fatalf("unexpected error `%s`", err)
+	}
+	if !ready {
+		t.Errorf("expected the release to be ready")
+	}
+}
@@ -0,0 +1,49 @@
+package steer
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/rodcloutier/helm-steer/pkg/executor"
+	"github.com/rodcloutier/helm-steer/pkg/format"
+)
+
+// operationReport is the outcome of an operation performed, or undone, by a
+// run
+type operationReport struct {
+	Description string
+	Result      executor.Result
+	Err         error
+	// The operation undoes a previous one
+	Undo bool
+}
+
+// printReport prints the outcome of each operation in the order they were
+// performed
+func printReport(report []operationReport) {
+	if len(report) == 0 {
+		return
+	}
+	fmt.Println(format.Important("Report"))
+	for _, r := range report {
+		outcome := format.Highlight("Success")
+		if r.Err != nil {
+			outcome = format.Error("Failed: " + r.Err.Error())
+		}
+		details := ""
+		if r.Result.Revision != 0 {
+			details = fmt.Sprintf(" revision %d", r.Result.Revision)
+		}
+		if r.Result.Status != "" {
+			details += fmt.Sprintf(" %s", r.Result.Status)
+		}
+		if r.Result.Duration != 0 {
+			details += fmt.Sprintf(" in %s", r.Result.Duration-r.Result.Duration%time.Millisecond)
+		}
+		prefix := ""
+		if r.Undo {
+			prefix = "(undo) "
+		}
+		fmt.Printf("  %s%s: %s%s\n", prefix, r.Description, outcome, details)
+	}
+}
@@ -0,0 +1,72 @@
+package steer
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"text/tabwriter"
+	"time"
+
+	"github.com/rodcloutier/helm-steer/pkg/helm"
+)
+
+// StatusOptions are the options of a status summary
+type StatusOptions struct {
+	// The namespaces summarised (empty is all namespaces)
+	Namespaces []string
+	// Upgrade to the latest version satisfying the version ranges
+	Latest bool
+	// The cluster settings, overriding the ones of the plan
+	Cluster helm.Settings
+	// Proceed even if the cluster is not the one expected by the plan
+	IgnoreClusterIdentity bool
+}
+
+// Status writes to w a summary of the releases of the plan, their deployed
+// state and the action a run would perform
+func Status(w io.Writer, planPath string, opts StatusOptions) error {
+
+	pl, err := loadPlan(planPath, opts.Cluster, opts.IgnoreClusterIdentity, w, ioutil.Discard)
+	if err != nil {
+		return err
+	}
+
+	if err := pl.VerifyRepositories(opts.Namespaces); err != nil {
+		return err
+	}
+
+	deployed, err := helm.List()
+	if err != nil {
+		return fmt.Errorf("Failed to fetch helm list: %s", err)
+	}
+
+	statuses, err := pl.Status(opts.Namespaces, deployed, opts.Latest)
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAMESPACE\tRELEASE\tCHART\tDEPLOYED\tREVISION\tSTATUS\tLAST DEPLOYED\tACTION")
+	for _, s := range statuses {
+		revision, status, deployedChart, lastDeployed := "", "", "", ""
+		if s.Revision != 0 {
+			revision = fmt.Sprintf("%d", s.Revision)
+			status = s.Status
+			deployedChart = chartVersion(s.DeployedChart, s.DeployedVersion)
+		}
+		if !s.LastDeployed.IsZero() {
+			lastDeployed = s.LastDeployed.Format(time.RFC1123)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", s.Namespace, s.Name,
+			chartVersion(s.Chart, s.Version), deployedChart, revision, status, lastDeployed, s.Action)
+	}
+	return tw.Flush()
+}
+
+// chartVersion returns the chart followed by its version, if any
+func chartVersion(chart, version string) string {
+	if version == "" {
+		return chart
+	}
+	return chart + "-" + version
+}
@@ -3,55 +3,360 @@ package steer
 import (
 	"fmt"
 	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/rodcloutier/helm-steer/pkg/executor"
 	"github.com/rodcloutier/helm-steer/pkg/format"
+	"github.com/rodcloutier/helm-steer/pkg/helm"
+	"github.com/rodcloutier/helm-steer/pkg/lock"
 	"github.com/rodcloutier/helm-steer/pkg/plan"
 )
 
-func Steer(outputWriter, debugWriter io.Writer, planPath string, namespaces []string, dryRun bool) error {
+const (
+	// TransactionScopePlan undoes all the operations of the plan on failure
+	TransactionScopePlan = "plan"
+	// TransactionScopeNamespace only undoes the operations of the namespace
+	// that failed
+	TransactionScopeNamespace = "namespace"
+)
+
+// Options are the options of a steer run
+type Options struct {
+	// The namespaces targeted (empty is all namespaces)
+	Namespaces []string
+	// Only print the operations without performing them
+	DryRun bool
+	// Upgrade to the latest version satisfying the version ranges
+	Latest bool
+	// Allow upgrading a release to a different chart
+	AllowChartChange bool
+	// Take over the releases owned by another plan or without recorded owner
+	Adopt bool
+	// Selects the releases to process
+	Selector plan.Selector
+	// The atomic unit of operations, either the plan or each namespace
+	TransactionScope string
+	// The executor performing the release operations
+	Executor string
+	// The cluster settings, overriding the ones of the plan
+	Cluster helm.Settings
+	// Proceed even if the cluster is not the one expected by the plan
+	IgnoreClusterIdentity bool
+	// The backend used to lock the namespaces, nil disables locking
+	LockBackend lock.Backend
+	// The time to wait for the namespaces held by another run
+	LockTimeout time.Duration
+	// The age after which a lock is considered stale and broken
+	LockStaleAfter time.Duration
+}
+
+func Steer(outputWriter, debugWriter io.Writer, planPath string, opts Options) error {
+
+	pl, err := loadPlan(planPath, opts.Cluster, opts.IgnoreClusterIdentity, os.Stdout, debugWriter)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Targeting %s\n", format.Highlight(pl.Cluster.Merge(opts.Cluster).String()))
+
+	namespaces, dryRun := opts.Namespaces, opts.DryRun
+
+	if opts.LockBackend != nil && !dryRun {
+		names := pl.NamespaceNames(namespaces)
+		fmt.Fprintf(debugWriter, "Locking namespaces %s ...\n", names)
+		l, err := lock.Acquire(opts.LockBackend, names, lock.NewInfo(planPath), opts.LockTimeout, opts.LockStaleAfter)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if err := l.Release(); err != nil {
+				fmt.Println("Failed to release the namespaces lock")
+				format.Ferror(outputWriter, err)
+			}
+		}()
+	}
+
+	// Missing repositories must be detected before any operation is performed
+	if err := pl.VerifyRepositories(namespaces); err != nil {
+		return err
+	}
+	// The flags unknown to the helm binary must be detected before any
+	// operation is performed
+	if opts.Executor == "" || opts.Executor == plan.ExecutorCLI {
+		version, err := helm.ClientVersion()
+		if err != nil {
+			return err
+		}
+		if err := pl.VerifyFlags(namespaces, version); err != nil {
+			return err
+		}
+	}
+
+	repositories, err := pl.RepositoryCommands()
+	if err != nil {
+		return err
+	}
+	// A dry run does not add the repositories, the version ranges of their
+	// charts cannot be resolved
+	var unresolved []string
+	if dryRun {
+		printCommands(debugWriter, repositories)
+		if unresolved, err = pl.PendingRepositories(); err != nil {
+			return err
+		}
+	} else if err := runCommands(outputWriter, debugWriter, repositories); err != nil {
+		fmt.Println(format.Error("Error: Failed to update the repositories"))
+		return err
+	}
+
+	// The local charts dependencies must be present to compute their digest
+	dependencyBuilds, err := pl.DependencyBuildCommands(namespaces, opts.Selector)
+	if err != nil {
+		return err
+	}
+	if dryRun {
+		printCommands(debugWriter, dependencyBuilds)
+	} else if err := runCommands(outputWriter, debugWriter, dependencyBuilds); err != nil {
+		fmt.Println(format.Error("Error: Failed to build the local charts dependencies"))
+		return err
+	}
 
-	pl, err := plan.Load(planPath)
+	operations, err := pl.Process(plan.ProcessOptions{
+		Namespaces:             namespaces,
+		Latest:                 opts.Latest,
+		AllowChartChange:       opts.AllowChartChange,
+		Adopt:                  opts.Adopt,
+		Selector:               opts.Selector,
+		Executor:               opts.Executor,
+		UnresolvedRepositories: unresolved,
+	})
 	if err != nil {
 		return err
 	}
 
-	operations, err := pl.Process(namespaces)
+	prePlan, err := pl.HookCommands("prePlan", pl.Hooks.PrePlan)
+	if err != nil {
+		return err
+	}
+	postPlan, err := pl.HookCommands("postPlan", pl.Hooks.PostPlan)
+	if err != nil {
+		return err
+	}
+	onFailure, err := pl.HookCommands("onFailure", pl.Hooks.OnFailure)
 	if err != nil {
 		return err
 	}
 
-	// Build the actual commands
-	operationStack := []plan.UndoableOperation{}
+	if dryRun {
+		printCommands(debugWriter, prePlan)
+	} else if err := runCommands(outputWriter, debugWriter, prePlan); err != nil {
+		fmt.Println(format.Error("Error: prePlan hook failed"))
+		return err
+	}
+
+	if dryRun {
+		for _, operation := range operations {
+			run := operation.Run
+			fmt.Println(format.Important(run.Description))
+			printCommands(debugWriter, operation.Wait)
+			printCommands(debugWriter, run.Pre)
+			fmt.Fprintf(debugWriter, "Executing `%s` ...\n", run.Command)
+			printCommands(debugWriter, run.Post)
+		}
+		printCommands(debugWriter, postPlan)
+		return nil
+	}
+
+	var scope func(plan.UndoableOperation) string
+	switch opts.TransactionScope {
+	case "", TransactionScopePlan:
+		scope = func(plan.UndoableOperation) string { return "" }
+	case TransactionScopeNamespace:
+		scope = func(op plan.UndoableOperation) string { return op.Namespace }
+	default:
+		return fmt.Errorf("Unknown transaction scope `%s`", opts.TransactionScope)
+	}
+
+	// The release commands share a connection to Tiller for the whole run
+	defer helm.Close()
+
+	failed, report := execute(outputWriter, debugWriter, operations, scope)
+	printReport(report)
+
+	if opts.TransactionScope == TransactionScopeNamespace {
+		printResults(operations, failed)
+	}
+
+	if len(failed) != 0 {
+		if err := runCommands(outputWriter, debugWriter, onFailure); err != nil {
+			fmt.Println("Failed while running onFailure hook")
+			format.Ferror(outputWriter, err)
+		}
+		if err, ok := failed[""]; ok {
+			return err
+		}
+		names := []string{}
+		for name := range failed {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return fmt.Errorf("Failed namespaces: %s", strings.Join(names, ", "))
+	}
+
+	if err := runCommands(outputWriter, debugWriter, postPlan); err != nil {
+		fmt.Println(format.Error("Error: postPlan hook failed"))
+		return err
+	}
+	return nil
+}
+
+// execute performs the operations in order. Each operation belongs to the
+// transaction returned by scope. When an operation fails, the operations
+// already performed in its transaction are undone and its remaining
+// operations are skipped, as are the operations depending on a release of
+// a failed transaction. It returns the error of each failed transaction and
+// the report of the operations performed.
+func execute(outputWriter, debugWriter io.Writer, operations []plan.UndoableOperation, scope func(plan.UndoableOperation) string) (map[string]error, []operationReport) {
+
+	stacks := map[string][]plan.UndoableOperation{}
+	failed := map[string]error{}
+	report := []operationReport{}
+
+	releaseScopes := map[string]string{}
+	for _, operation := range operations {
+		if operation.Release != "" {
+			releaseScopes[operation.Release] = scope(operation)
+		}
+	}
+
 	for _, operation := range operations {
+		key := scope(operation)
+		if _, ok := failed[key]; ok {
+			continue
+		}
+
 		run := operation.Run
 		fmt.Println(format.Important(run.Description))
-		cmd := executor.NewExecutableCommand("helm", run.Command)
-		fmt.Fprintf(debugWriter, "Executing `%s` ...\n", cmd)
-		if dryRun {
-			continue
+
+		var err error
+		var result executor.Result
+		for _, dep := range operation.Depends {
+			if s, ok := releaseScopes[dep]; ok && failed[s] != nil {
+				err = fmt.Errorf("Dependency %s failed", dep)
+				format.Ferror(outputWriter, err)
+			}
+		}
+		if err == nil {
+			err = runCommands(outputWriter, debugWriter, operation.Wait)
 		}
-		err = cmd.Run(outputWriter)
+		if err == nil {
+			err = runCommands(outputWriter, debugWriter, run.Pre)
+		}
+		if err == nil {
+			fmt.Fprintf(debugWriter, "Executing `%s` ...\n", run.Command)
+			result, err = run.Command.Run(outputWriter)
+		}
+		if err == nil {
+			// The operation was performed, it must be undone if a hook or a
+			// check fails
+			stacks[key] = append([]plan.UndoableOperation{operation}, stacks[key]...)
+			err = runCommands(outputWriter, debugWriter, run.Post)
+		}
+		if err == nil {
+			err = runChecks(outputWriter, debugWriter, operation.Checks)
+		}
+		report = append(report, operationReport{Description: run.Description, Result: result, Err: err})
 		if err == nil {
 			fmt.Println(format.Highlight("Success"))
-		} else {
-			fmt.Println(format.Error("Error: Last operation failed"))
+			continue
+		}
+
+		fmt.Println(format.Error("Error: Last operation failed: " + err.Error()))
+		if key == "" {
 			fmt.Println("Undoing previous operations")
-			// Undo the commands
-			for _, operation := range operationStack {
-				undo := operation.Undo
-				cmd := executor.NewExecutableCommand("helm", undo.Command)
-				fmt.Println(format.Important(undo.Description))
-				fmt.Fprintf(debugWriter, "Executing `%s` ...\n", cmd)
-				err := cmd.Run(outputWriter)
-				if err != nil {
-					fmt.Println("Failed while undoing command")
-					format.Ferror(outputWriter, err)
-				}
-			}
+		} else {
+			fmt.Printf("Undoing previous operations in namespace %s\n", key)
+		}
+		report = append(report, undo(outputWriter, debugWriter, stacks[key])...)
+		failed[key] = err
+	}
+	return failed, report
+}
+
+// undo performs the undo of the operations of the stack and returns their
+// report
+func undo(outputWriter, debugWriter io.Writer, stack []plan.UndoableOperation) []operationReport {
+	report := []operationReport{}
+	for _, operation := range stack {
+		undo := operation.Undo
+		if undo.Command == nil {
+			continue
+		}
+		fmt.Println(format.Important(undo.Description))
+		var result executor.Result
+		err := runCommands(outputWriter, debugWriter, undo.Pre)
+		if err == nil {
+			fmt.Fprintf(debugWriter, "Executing `%s` ...\n", undo.Command)
+			result, err = undo.Command.Run(outputWriter)
+		}
+		if err == nil {
+			err = runCommands(outputWriter, debugWriter, undo.Post)
+		}
+		report = append(report, operationReport{Description: undo.Description, Result: result, Err: err, Undo: true})
+		if err != nil {
+			fmt.Println("Failed while undoing command")
+			format.Ferror(outputWriter, err)
+		}
+	}
+	return report
+}
+
+// printResults prints the result of each namespace transaction
+func printResults(operations []plan.UndoableOperation, failed map[string]error) {
+	printed := map[string]bool{}
+	for _, operation := range operations {
+		ns := operation.Namespace
+		if printed[ns] {
+			continue
+		}
+		printed[ns] = true
+		if err, ok := failed[ns]; ok {
+			fmt.Printf("Namespace %s: %s\n", ns, format.Error(fmt.Sprintf("Failed: %s", err)))
+		} else {
+			fmt.Printf("Namespace %s: %s\n", ns, format.Highlight("Success"))
+		}
+	}
+}
+
+// runCommands executes the commands in order and stops on the first failure
+func runCommands(outputWriter, debugWriter io.Writer, cmds []executor.Command) error {
+	for _, cmd := range cmds {
+		fmt.Fprintf(debugWriter, "Executing `%s` ...\n", cmd)
+		if _, err := cmd.Run(outputWriter); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// printCommands prints the commands that would be executed
+func printCommands(debugWriter io.Writer, cmds []executor.Command) {
+	for _, cmd := range cmds {
+		fmt.Fprintf(debugWriter, "Executing `%s` ...\n", cmd)
+	}
+}
+
+// runChecks performs the checks in order and stops on the first failure
+func runChecks(outputWriter, debugWriter io.Writer, checks []executor.Command) error {
+	for _, check := range checks {
+		fmt.Printf("Checking %s\n", check)
+		fmt.Fprintf(debugWriter, "Executing `%s` ...\n", check)
+		if _, err := check.Run(outputWriter); err != nil {
+			format.Ferror(outputWriter, err)
 			return err
 		}
-		operationStack = append([]plan.UndoableOperation{operation}, operationStack...)
 	}
 	return nil
 }
@@ -0,0 +1,146 @@
+package steer
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"testing"
+
+	"github.com/rodcloutier/helm-steer/pkg/executor"
+	"github.com/rodcloutier/helm-steer/pkg/plan"
+)
+
+// fakeCommand appends its name to the log when run and fails if requested
+type fakeCommand struct {
+	name string
+	fail bool
+	log  *[]string
+}
+
+func (c fakeCommand) String() string {
+	return c.name
+}
+
+func (c fakeCommand) Run(w io.Writer) (executor.Result, error) {
+	*c.log = append(*c.log, c.name)
+	if c.fail {
+		return executor.Result{}, errors.New(c.name + " failed")
+	}
+	return executor.Result{}, nil
+}
+
+// fakeOperation returns the operation of the release, its command and undo
+// being logged
+func fakeOperation(log *[]string, namespace, release string, fail, failUndo bool) plan.UndoableOperation {
+	return plan.UndoableOperation{
+		Namespace: namespace,
+		Release:   release,
+		Run: plan.Operation{
+			Description: "Installing " + release,
+			Command:     fakeCommand{"install " + release, fail, log},
+		},
+		Undo: plan.Operation{
+			Description: "Deleting " + release,
+			Command:     fakeCommand{"delete " + release, failUndo, log},
+		},
+	}
+}
+
+func TestExecuteUndoOrder(t *testing.T) {
+
+	// --- conditions----------------------------------------------------------
+	log := []string{}
+	failing := fakeOperation(&log, "foo", "web", false, false)
+	failing.Checks = []executor.Command{fakeCommand{"check web", true, &log}}
+	operations := []plan.UndoableOperation{
+		fakeOperation(&log, "foo", "db", false, false),
+		fakeOperation(&log, "foo", "api", false, false),
+		failing,
+		fakeOperation(&log, "foo", "worker", false, false),
+	}
+	scope := func(plan.UndoableOperation) string { return "" }
+
+	// --- call ---------------------------------------------------------------
+	failed, report := execute(ioutil.Discard, ioutil.Discard, operations, scope)
+
+	// --- test ---------------------------------------------------------------
+	// The operation whose check failed is undone first, the remaining ones
+	// are skipped
+	expected := []string{
+		"install db", "install api", "install web", "check web",
+		"delete web", "delete api", "delete db",
+	}
+	if !reflect.DeepEqual(log, expected) {
+		t.Errorf("expected %q, got %q", expected, log)
+	}
+	if err := failed[""]; err == nil || err.Error() != "check web failed" {
+		t.Errorf("expected the check failure, got `%v`", err)
+	}
+	if len(report) != 6 || !report[3].Undo || report[2].Err == nil {
+		t.Errorf("unexpected report %+v", report)
+	}
+}
+
+func TestExecuteFailedUndo(t *testing.T) {
+
+	// --- conditions----------------------------------------------------------
+	log := []string{}
+	operations := []plan.UndoableOperation{
+		fakeOperation(&log, "foo", "db", false, false),
+		fakeOperation(&log, "foo", "api", false, true),
+		fakeOperation(&log, "foo", "web", true, false),
+	}
+	scope := func(plan.UndoableOperation) string { return "" }
+
+	// --- call ---------------------------------------------------------------
+	failed, report := execute(ioutil.Discard, ioutil.Discard, operations, scope)
+
+	// --- test ---------------------------------------------------------------
+	// A failed undo does not stop the undo of the previous operations
+	expected := []string{"install db", "install api", "install web", "delete api", "delete db"}
+	if !reflect.DeepEqual(log, expected) {
+		t.Errorf("expected %q, got %q", expected, log)
+	}
+	if err := failed[""]; err == nil || err.Error() != "install web failed" {
+		t.Errorf("expected the install failure, got `%v`", err)
+	}
+	if len(report) != 5 {
+		t.Fatalf("expected 5 reported operations, got %+v", report)
+	}
+	if r := report[3]; !r.Undo || r.Err == nil || r.Description != "Deleting api" {
+		t.Errorf("expected the failed undo of api to be reported, got %+v", r)
+	}
+	if r := report[4]; !r.Undo || r.Err != nil {
+		t.Errorf("expected the undo of db to succeed, got %+v", r)
+	}
+}
+
+func TestExecuteNamespaceScope(t *testing.T) {
+
+	// --- conditions----------------------------------------------------------
+	log := []string{}
+	dependent := fakeOperation(&log, "bar", "web", false, false)
+	dependent.Depends = []string{"api"}
+	operations := []plan.UndoableOperation{
+		fakeOperation(&log, "foo", "db", false, false),
+		fakeOperation(&log, "bar", "cache", false, false),
+		fakeOperation(&log, "foo", "api", true, false),
+		dependent,
+	}
+	scope := func(op plan.UndoableOperation) string { return op.Namespace }
+
+	// --- call ---------------------------------------------------------------
+	failed, _ := execute(ioutil.Discard, ioutil.Discard, operations, scope)
+
+	// --- test ---------------------------------------------------------------
+	// Only the namespace of the failure is undone, the release depending on
+	// it fails its own namespace
+	expected := []string{"install db", "install cache", "install api", "delete db", "delete cache"}
+	if !reflect.DeepEqual(log, expected) {
+		t.Errorf("expected %q, got %q", expected, log)
+	}
+	if failed["foo"] == nil || failed["bar"] == nil {
+		t.Errorf("expected both namespaces to fail, got %v", failed)
+	}
+}